@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/user"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/urfave/cli/v2"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	auditDestinationSyslog = "syslog"
+)
+
+var (
+	// Audit log of the current command - records every kubeconfig and token qbconf issues
+	auditLog AuditLog
+
+	// Environment variables holding the URL of the current CI run, per CI system
+	ciRunURLEnvVarNames = []string{"CI_JOB_URL", "BUILD_URL", "CIRCLE_BUILD_URL", "BUILDKITE_BUILD_URL"}
+)
+
+// AuditLog writes append-only JSON Lines records to a file, syslog or an HTTP endpoint
+type AuditLog struct {
+	Destination string
+}
+
+// AuditRecord describes credentials issued by qbconf - it never holds the credentials themselves
+type AuditRecord struct {
+	Time           time.Time  `json:"time"`
+	RequestUUID    string     `json:"request_uuid"`
+	Command        string     `json:"command"`
+	User           string     `json:"user,omitempty"`
+	Host           string     `json:"host,omitempty"`
+	CIRunURL       string     `json:"ci_run_url,omitempty"`
+	CallerArn      string     `json:"caller_arn,omitempty"`
+	RoleArn        string     `json:"role_arn,omitempty"`
+	SessionName    string     `json:"session_name,omitempty"`
+	ClusterName    string     `json:"cluster_name,omitempty"`
+	ClusterArn     string     `json:"cluster_arn,omitempty"`
+	CredentialType string     `json:"credential_type"`
+	Expiry         *time.Time `json:"expiry,omitempty"`
+}
+
+// Returns the flags configuring the audit log
+func auditFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:     "audit-log",
+			Usage:    "Records every issued kubeconfig or token to a file path, syslog, syslog://host:port or an http(s):// endpoint",
+			EnvVars:  []string{"QBCONF_AUDIT_LOG"},
+			Required: false,
+		},
+	}
+}
+
+// Creates the audit log from the command flags
+func newAuditLog(c *cli.Context) AuditLog {
+	return AuditLog{Destination: c.String("audit-log")}
+}
+
+// Returns the URL of the CI run qbconf is running in ( empty outside of CI )
+func ciRunURL() string {
+
+	if runID := os.Getenv("GITHUB_RUN_ID"); runID != "" {
+		return fmt.Sprintf("%s/%s/actions/runs/%s", os.Getenv("GITHUB_SERVER_URL"), os.Getenv("GITHUB_REPOSITORY"), runID)
+	}
+
+	for _, name := range ciRunURLEnvVarNames {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+	}
+
+	return ""
+}
+
+// Returns the name of the local user running qbconf
+func localUsername() string {
+
+	if current, err := user.Current(); err == nil {
+		return current.Username
+	}
+
+	return os.Getenv("USER")
+}
+
+// Creates an audit record holding the details shared by all credentials of a run
+func newAuditRecord(c *cli.Context, identity *sts.GetCallerIdentityOutput) AuditRecord {
+
+	hostname, _ := os.Hostname()
+
+	record := AuditRecord{
+		Time:        time.Now().UTC(),
+		RequestUUID: reqUuid,
+		Command:     c.Command.FullName(),
+		User:        localUsername(),
+		Host:        hostname,
+		CIRunURL:    ciRunURL(),
+		CallerArn:   aws.ToString(identity.Arn),
+		RoleArn:     getAssumedRoleArn(c),
+	}
+	if record.RoleArn != "" {
+		record.SessionName = roleSession.Name
+	}
+
+	return record
+}
+
+// Function to record the credentials of a generated kubeconfig in the audit log
+func auditKubeconfig(c *cli.Context, identity *sts.GetCallerIdentityOutput, kubeconfigByteArr []byte) error {
+
+	if auditLog.Destination == "" {
+		return nil
+	}
+
+	config, err := clientcmd.Load(kubeconfigByteArr)
+	if err != nil {
+		return err
+	}
+
+	contextNames := make([]string, 0, len(config.Contexts))
+	for name := range config.Contexts {
+		contextNames = append(contextNames, name)
+	}
+	sort.Strings(contextNames)
+
+	for _, name := range contextNames {
+		kubeContext := config.Contexts[name]
+		record := newAuditRecord(c, identity)
+		record.ClusterName = kubeContext.Cluster
+
+		if cluster, ok := config.Clusters[kubeContext.Cluster]; ok {
+			if provenance := getProvenance(cluster.Extensions); provenance != nil {
+				record.ClusterName, record.ClusterArn = provenance.ClusterName, provenance.ClusterArn
+			}
+		}
+
+		authInfo, ok := config.AuthInfos[kubeContext.AuthInfo]
+		switch {
+		case !ok:
+			continue
+		case authInfo.Token != "":
+			record.CredentialType = "token"
+			if expiration, err := getTokenExpiration(authInfo.Token); err == nil {
+				record.Expiry = &expiration
+			}
+		case authInfo.Exec != nil:
+			record.CredentialType = "exec"
+		default:
+			continue
+		}
+
+		if err := auditLog.write(c.Context, record); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Function to record a bearer token issued outside of a kubeconfig ( e.g. by the proxy ) in the audit log
+func auditToken(c *cli.Context, identity *sts.GetCallerIdentityOutput, clusterName, clusterArn, token string) error {
+
+	if auditLog.Destination == "" {
+		return nil
+	}
+
+	record := newAuditRecord(c, identity)
+	record.ClusterName, record.ClusterArn, record.CredentialType = clusterName, clusterArn, "token"
+	if expiration, err := getTokenExpiration(token); err == nil {
+		record.Expiry = &expiration
+	}
+
+	return auditLog.write(c.Context, record)
+}
+
+// Writes a single record to the audit log destination
+func (a AuditLog) write(ctx context.Context, record AuditRecord) error {
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	logSugar.Infow("writing audit record", "destination", a.Destination, "cluster", record.ClusterName, "credential_type", record.CredentialType)
+
+	switch {
+	case a.Destination == auditDestinationSyslog || strings.HasPrefix(a.Destination, auditDestinationSyslog+"://"):
+		return writeAuditSyslog(a.Destination, line)
+	case strings.HasPrefix(a.Destination, "http://") || strings.HasPrefix(a.Destination, "https://"):
+		return retryPolicy.do(ctx, "write audit record", func() error {
+			return writeAuditHTTP(ctx, a.Destination, line)
+		})
+	default:
+		return writeAuditFile(strings.TrimPrefix(a.Destination, "file://"), line)
+	}
+}
+
+// Appends a record to the audit log file
+func writeAuditFile(path string, line []byte) error {
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer file.Close()
+
+	_, err = file.Write(append(line, '\n'))
+	return err
+}
+
+// Posts a record to the audit log HTTP endpoint
+func writeAuditHTTP(ctx context.Context, endpoint string, line []byte) error {
+
+	client, err := network.newHTTPClient()
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(line))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return &httpStatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+
+	return nil
+}
+
+// Splits a syslog://host:port destination into the network address ( empty for the local daemon )
+func syslogAddress(destination string) (string, error) {
+
+	if destination == auditDestinationSyslog {
+		return "", nil
+	}
+
+	parsedURL, err := url.Parse(destination)
+	if err != nil || parsedURL.Host == "" {
+		return "", fmt.Errorf("invalid audit log destination %q - expected syslog://host:port", destination)
+	}
+
+	return parsedURL.Host, nil
+}
@@ -0,0 +1,29 @@
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"log/syslog"
+)
+
+// Sends a record to the local syslog daemon or a remote one over UDP
+func writeAuditSyslog(destination string, line []byte) error {
+
+	address, err := syslogAddress(destination)
+	if err != nil {
+		return err
+	}
+
+	network := ""
+	if address != "" {
+		network = "udp"
+	}
+
+	writer, err := syslog.Dial(network, address, syslog.LOG_INFO|syslog.LOG_AUTH, "qbconf")
+	if err != nil {
+		return err
+	}
+	defer writer.Close()
+
+	return writer.Info(string(line))
+}
@@ -0,0 +1,13 @@
+//go:build windows || plan9
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// Syslog is not available on this platform
+func writeAuditSyslog(destination string, line []byte) error {
+	return fmt.Errorf("syslog audit log is not supported on %s", runtime.GOOS)
+}
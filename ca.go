@@ -0,0 +1,74 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/eks/types"
+)
+
+// clusterCertificateAuthority is the parsed certificate authority of an EKS cluster
+type clusterCertificateAuthority struct {
+	// PEM encoded data as written to the kubeconfig
+	Data     []byte
+	Subject  string
+	NotAfter time.Time
+	// Hex encoded SHA-256 fingerprint of the DER encoded certificate
+	SHA256 string
+}
+
+// Function to decode and validate the certificate authority returned by DescribeCluster
+func parseClusterCertificateAuthority(cluster *types.Cluster) (*clusterCertificateAuthority, error) {
+
+	if cluster.CertificateAuthority == nil || aws.ToString(cluster.CertificateAuthority.Data) == "" {
+		return nil, fmt.Errorf("EKS cluster %s has no certificate authority", aws.ToString(cluster.Name))
+	}
+
+	data, err := base64.StdEncoding.DecodeString(aws.ToString(cluster.CertificateAuthority.Data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode certificate authority of EKS cluster %s: %w", aws.ToString(cluster.Name), err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("certificate authority of EKS cluster %s is not a PEM encoded certificate", aws.ToString(cluster.Name))
+	}
+
+	certificate, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate authority of EKS cluster %s: %w", aws.ToString(cluster.Name), err)
+	}
+
+	fingerprint := sha256.Sum256(certificate.Raw)
+
+	return &clusterCertificateAuthority{
+		Data:     data,
+		Subject:  certificate.Subject.String(),
+		NotAfter: certificate.NotAfter,
+		SHA256:   hex.EncodeToString(fingerprint[:]),
+	}, nil
+}
+
+// Normalizes a SHA-256 fingerprint ( accepts an optional sha256: prefix, colons and upper case )
+func normalizeFingerprint(fingerprint string) string {
+
+	fingerprint = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(fingerprint)), "sha256:")
+	return strings.ReplaceAll(fingerprint, ":", "")
+}
+
+// Logs the subject, expiry and fingerprint of the cluster certificate authority
+func logCertificateAuthority(ca *clusterCertificateAuthority) {
+
+	logSugar.Infow("cluster certificate authority", "subject", ca.Subject, "not_after", ca.NotAfter.UTC().Format(time.RFC3339), "sha256", ca.SHA256)
+
+	if time.Now().After(ca.NotAfter) {
+		logSugar.Warnw("cluster certificate authority has expired", "subject", ca.Subject, "not_after", ca.NotAfter.UTC().Format(time.RFC3339))
+	}
+}
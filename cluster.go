@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/eks/types"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	// Backoff between DescribeCluster polls while waiting for a cluster to become active
+	clusterPollBaseInterval = 5 * time.Second
+	clusterPollMaxInterval  = 30 * time.Second
+)
+
+// Returns the flags shared by all commands issuing credentials for an EKS cluster
+func eksClusterFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:     "cluster-id",
+			Usage:    "Cluster identifier the token is issued for ( defaults to the cluster ID for EKS local clusters on Outposts, the cluster name otherwise )",
+			Required: false,
+		},
+		&cli.BoolFlag{
+			Name:  "wait-for-active",
+			Usage: "Waits until the cluster is ACTIVE and its endpoint answers ( e.g. right after CreateCluster )",
+			Value: false,
+		},
+		&cli.StringFlag{
+			Name:     "expected-ca-sha256",
+			Usage:    "SHA-256 fingerprint the cluster certificate authority has to match - refuses to issue credentials otherwise",
+			EnvVars:  []string{"QBCONF_EXPECTED_CA_SHA256"},
+			Required: false,
+		},
+		&cli.DurationFlag{
+			Name:    "wait-timeout",
+			Usage:   "Maximum time --wait-for-active waits for the cluster to become active",
+			EnvVars: []string{"QBCONF_WAIT_TIMEOUT"},
+			Value:   20 * time.Minute,
+		},
+	}
+}
+
+// Function to describe an EKS cluster and make sure it is ready to be used ( optionally waiting for it )
+func getReadyEKSCluster(ctx context.Context, eksClusterName string, waitForActive bool, timeout time.Duration, access endpointAccess) (*types.Cluster, error) {
+
+	deadline := time.Now().Add(timeout)
+	pollInterval := clusterPollBaseInterval
+
+	for {
+		cluster, err := describeEKSCluster(ctx, eksClusterName)
+		if err != nil {
+			return nil, err
+		}
+
+		err = checkClusterReady(cluster)
+		if err == nil && waitForActive {
+			err = checkClusterEndpointReachability(ctx, cluster, access)
+		}
+		if err == nil {
+			return cluster, checkPinnedCertificateAuthority(cluster)
+		}
+
+		if !waitForActive || (!isClusterTransitioning(cluster) && cluster.Status != types.ClusterStatusActive) {
+			return nil, err
+		}
+
+		if time.Now().Add(pollInterval).After(deadline) {
+			return nil, newQbconfError(errorClassClusterNotReady, fmt.Errorf("cluster %s did not become active within --wait-timeout %s: %w", eksClusterName, timeout, err))
+		}
+
+		logSugar.Infow("waiting for EKS cluster to become active", "cluster", eksClusterName, "status", cluster.Status, "reason", err.Error(), "wait_time", pollInterval)
+		if err := sleepContext(ctx, pollInterval); err != nil {
+			return nil, err
+		}
+
+		pollInterval *= 2
+		if pollInterval > clusterPollMaxInterval {
+			pollInterval = clusterPollMaxInterval
+		}
+	}
+}
+
+// Returns the identifier the bearer token has to be issued for ( x-k8s-aws-id header )
+func getTokenClusterID(cluster *types.Cluster, clusterIDOverride string) string {
+
+	if clusterIDOverride != "" {
+		return clusterIDOverride
+	}
+
+	// EKS local clusters on Outposts authenticate tokens against the cluster ID instead of the name
+	if cluster.OutpostConfig != nil && aws.ToString(cluster.Id) != "" {
+		logSugar.Infow("cluster is an EKS local cluster on Outposts - issuing token for the cluster ID", "cluster", aws.ToString(cluster.Name), "cluster_id", aws.ToString(cluster.Id))
+		return aws.ToString(cluster.Id)
+	}
+
+	return aws.ToString(cluster.Name)
+}
+
+// Checks the cluster status and that the endpoint and certificate authority are populated
+func checkClusterReady(cluster *types.Cluster) error {
+
+	name := aws.ToString(cluster.Name)
+
+	switch cluster.Status {
+	case types.ClusterStatusFailed, types.ClusterStatusDeleting:
+		return newQbconfError(errorClassClusterNotReady, fmt.Errorf("cluster %s is %s and can not be used", name, cluster.Status))
+	case types.ClusterStatusCreating, types.ClusterStatusPending:
+		return newQbconfError(errorClassClusterNotReady, fmt.Errorf("cluster %s is still %s - use --wait-for-active to wait for it", name, cluster.Status))
+	case types.ClusterStatusUpdating:
+		logSugar.Warnw("cluster is being updated - the endpoint stays available", "cluster", name)
+	}
+
+	if aws.ToString(cluster.Endpoint) == "" {
+		return newQbconfError(errorClassClusterNotReady, fmt.Errorf("cluster %s has no endpoint yet", name))
+	}
+	if cluster.CertificateAuthority == nil || aws.ToString(cluster.CertificateAuthority.Data) == "" {
+		return newQbconfError(errorClassClusterNotReady, fmt.Errorf("cluster %s has no certificate authority data yet", name))
+	}
+
+	return nil
+}
+
+// Checks if the cluster is in a state which eventually leads to ACTIVE
+func isClusterTransitioning(cluster *types.Cluster) bool {
+	switch cluster.Status {
+	case types.ClusterStatusCreating, types.ClusterStatusPending, types.ClusterStatusUpdating:
+		return true
+	default:
+		return false
+	}
+}
+
+// Checks the TLS handshake against the cluster endpoint using the cluster certificate authority
+func checkClusterEndpointReachability(ctx context.Context, cluster *types.Cluster, access endpointAccess) error {
+
+	name := aws.ToString(cluster.Name)
+
+	// The API server is reached through a bastion or tunnel which may only exist once the kubeconfig is used
+	if access.ProxyURL != "" || access.Server != "" {
+		logSugar.Infow("skipping endpoint reachability check - the API server is reached through --proxy-url / --server", "cluster", name)
+		return nil
+	}
+
+	ca, err := parseClusterCertificateAuthority(cluster)
+	if err != nil {
+		return err
+	}
+
+	err = checkTLSReachability(ctx, aws.ToString(cluster.Endpoint), ca.Data)
+	if err != nil && cluster.ResourcesVpcConfig != nil && !cluster.ResourcesVpcConfig.EndpointPublicAccess {
+		logSugar.Warnw("the private only endpoint of the EKS cluster is not reachable from here - not waiting for it", "cluster", name, "error", err)
+		return nil
+	}
+
+	return err
+}
+
+// Checks the cluster certificate authority against the --expected-ca-sha256 guardrail
+func checkPinnedCertificateAuthority(cluster *types.Cluster) error {
+
+	if guardrails.ExpectedCASHA256 == "" {
+		return nil
+	}
+
+	ca, err := parseClusterCertificateAuthority(cluster)
+	if err != nil {
+		return err
+	}
+
+	return guardrails.checkCertificateAuthority(ca)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+var (
+	// Cancels the root context once the command finished
+	cancelRootContext context.CancelFunc = func() {}
+)
+
+// Returns the global flags bounding the duration of a run
+func rootContextFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.DurationFlag{
+			Name:    "timeout",
+			Usage:   "Maximum duration of the whole run - AWS, GitHub and cluster calls are cancelled afterwards ( 0 disables it )",
+			EnvVars: []string{"QBCONF_TIMEOUT"},
+			Value:   0,
+		},
+	}
+}
+
+// Function to bound the root context ( already cancelled on SIGINT / SIGTERM ) by the --timeout flag
+func configureRootContext(c *cli.Context) error {
+
+	timeout := c.Duration("timeout")
+	if timeout <= 0 {
+		return nil
+	}
+
+	logSugar.Debugw("bounding run duration", "timeout", timeout)
+	c.Context, cancelRootContext = context.WithTimeout(c.Context, timeout)
+
+	return nil
+}
+
+// Sleeps for the given duration unless the context is cancelled first
+func sleepContext(ctx context.Context, duration time.Duration) error {
+
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return classifyAWSError(ctx.Err())
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/eks/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	doctorStatusPass = "pass"
+	doctorStatusWarn = "warn"
+	doctorStatusFail = "fail"
+	doctorStatusSkip = "skip"
+
+	// Timeout of the network checks towards STS and the cluster endpoint
+	doctorDialTimeout = 5 * time.Second
+)
+
+var (
+	awsRegionRegexp = regexp.MustCompile(`^[a-z]{2}(-[a-z]+)+-\d$`)
+
+	// Checks run by doctor aws in order
+	doctorChecksAWS = []string{
+		"credentials",
+		"region",
+		"sts-reachability",
+		"caller-identity",
+		"assume-role",
+		"guardrails",
+		"describe-cluster",
+		"cluster-status",
+		"certificate-authority",
+		"endpoint-reachability",
+		"authentication",
+	}
+)
+
+// DoctorCheck is the outcome of a single diagnostic check
+type DoctorCheck struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+	Hint    string `json:"hint,omitempty"`
+}
+
+// doctorReport collects the checks in the order they ran
+type doctorReport struct {
+	Checks []DoctorCheck `json:"checks"`
+	failed bool
+}
+
+// Records a check result
+func (r *doctorReport) add(name, status, message, hint string) {
+	r.Checks = append(r.Checks, DoctorCheck{Name: name, Status: status, Message: message, Hint: hint})
+	r.failed = r.failed || status == doctorStatusFail
+}
+
+// Records all checks which did not run yet as skipped because an earlier check failed
+func (r *doctorReport) skipRemaining() {
+	for _, name := range doctorChecksAWS[len(r.Checks):] {
+		r.add(name, doctorStatusSkip, "skipped because an earlier check failed", "")
+	}
+}
+
+// Function to run end-to-end diagnostics from credentials up to authenticating against the EKS cluster
+func doctorAWS(c *cli.Context, out io.Writer) error {
+
+	report := &doctorReport{}
+	runDoctorChecksAWS(c, report)
+	report.skipRemaining()
+
+	switch c.String("output") {
+	case "json":
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			return err
+		}
+	case "text":
+		for _, check := range report.Checks {
+			fmt.Fprintf(out, "[%s] %s: %s\n", check.Status, check.Name, check.Message)
+			if check.Hint != "" && (check.Status == doctorStatusFail || check.Status == doctorStatusWarn) {
+				fmt.Fprintf(out, "       hint: %s\n", check.Hint)
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported output format: %s", c.String("output"))
+	}
+
+	if report.failed {
+		return cli.Exit("", 1)
+	}
+
+	return nil
+}
+
+// Runs the checks in order - every check depends on the ones before it
+func runDoctorChecksAWS(c *cli.Context, report *doctorReport) {
+
+	region, eksClusterName := c.String("region"), c.String("cluster-name")
+
+	// Same settings as generate aws so the checks assume the role exactly like it
+	if err := configureAWSCommand(c); err != nil {
+		report.add("credentials", doctorStatusFail, err.Error(), "Check --role-session-name and the other credential flags")
+		return
+	}
+
+	// credential resolution
+	cfg, err := loadAWSConfig(c.Context, region)
+	if err != nil {
+		report.add("credentials", doctorStatusFail, err.Error(), "Check AWS_PROFILE and the shared config / credentials files")
+		return
+	}
+	awsConfig = cfg
+
+	// AssumeRoleWithWebIdentity is unsigned - GitHub Actions runners usually have no default credentials at all
+	hasDefaultCredentials := true
+	creds, err := awsConfig.Credentials.Retrieve(c.Context)
+	switch {
+	case err != nil && c.Bool("with-gha-oidc"):
+		hasDefaultCredentials = false
+		report.add("credentials", doctorStatusSkip, "no default credentials - the role is assumed with the GitHub Actions OIDC token", "")
+	case err != nil:
+		report.add("credentials", doctorStatusFail, err.Error(), "Configure credentials via environment variables, AWS_PROFILE, SSO login or an instance / pod role")
+		return
+	default:
+		report.add("credentials", doctorStatusPass, fmt.Sprintf("resolved credentials from %s", creds.Source), "")
+	}
+
+	// region validity
+	if !awsRegionRegexp.MatchString(region) {
+		report.add("region", doctorStatusFail, fmt.Sprintf("%q is not a valid AWS region", region), "Pass a region like eu-west-1 via --region or AWS_REGION")
+		return
+	}
+	report.add("region", doctorStatusPass, region, "")
+
+	// STS reachability
+	stsHost := fmt.Sprintf("sts.%s.amazonaws.com", region)
+	if proxyURL, _ := network.proxyFunc()(&http.Request{URL: &url.URL{Scheme: "https", Host: stsHost}}); proxyURL != nil {
+		report.add("sts-reachability", doctorStatusWarn, "STS is reached through proxy "+proxyURL.Host+" - direct connection not checked", "")
+	} else if err := checkTCPReachability(c.Context, stsHost); err != nil {
+		report.add("sts-reachability", doctorStatusFail, err.Error(), "Check DNS, firewall rules and HTTPS_PROXY settings towards "+stsHost)
+		return
+	} else {
+		report.add("sts-reachability", doctorStatusPass, stsHost+":443 is reachable", "")
+	}
+
+	// GetCallerIdentity - the guardrails only apply to the final identity
+	principal := "the GitHub OIDC subject"
+	var identity *sts.GetCallerIdentityOutput
+	if hasDefaultCredentials {
+		identity, err = getCallerIdentity(c.Context, *awsConfig)
+		if err != nil {
+			report.add("caller-identity", doctorStatusFail, err.Error(), "The credentials are invalid or expired - refresh them ( e.g. aws sso login )")
+			return
+		}
+		principal = aws.ToString(identity.Arn)
+		report.add("caller-identity", doctorStatusPass, fmt.Sprintf("%s ( account %s )", aws.ToString(identity.Arn), aws.ToString(identity.Account)), "")
+	} else {
+		report.add("caller-identity", doctorStatusSkip, "no default credentials", "")
+	}
+
+	// assume-role or web-identity
+	if c.Bool("with-assume-role") || c.Bool("with-gha-oidc") {
+		var assumedIdentity *sts.GetCallerIdentityOutput
+		_, err := configureAWSCredentials(c, "doctor::aws")
+		if err == nil {
+			assumedIdentity, err = getCallerIdentity(c.Context, *awsConfig)
+		}
+		if err != nil {
+			report.add("assume-role", doctorStatusFail, err.Error(), "Check the role ARN and that its trust policy allows "+principal+" to assume it")
+			return
+		}
+		identity = assumedIdentity
+		report.add("assume-role", doctorStatusPass, "assumed "+aws.ToString(identity.Arn), "")
+	} else {
+		report.add("assume-role", doctorStatusSkip, "using default credentials", "")
+	}
+
+	// account and caller guardrails
+	if err := guardrails.checkIdentity(identity); err != nil {
+		report.add("guardrails", doctorStatusFail, err.Error(), "Check --allowed-account-ids, --forbidden-account-ids and --expected-caller-arn against the identity qbconf ends up with")
+		return
+	}
+	if len(guardrails.AllowedAccountIDs) == 0 && len(guardrails.ForbiddenAccountIDs) == 0 && guardrails.ExpectedCallerArn == "" {
+		report.add("guardrails", doctorStatusSkip, "no account or caller guardrails configured", "")
+	} else {
+		report.add("guardrails", doctorStatusPass, aws.ToString(identity.Arn)+" is allowed", "")
+	}
+
+	// eks:DescribeCluster permission
+	cluster, err := describeEKSCluster(c.Context, eksClusterName)
+	if err != nil {
+		report.add("describe-cluster", doctorStatusFail, err.Error(), "Check the cluster name, the region and that the identity is allowed eks:DescribeCluster")
+		return
+	}
+	report.add("describe-cluster", doctorStatusPass, aws.ToString(cluster.Arn), "")
+
+	// cluster status
+	switch cluster.Status {
+	case types.ClusterStatusActive:
+		report.add("cluster-status", doctorStatusPass, string(cluster.Status), "")
+	case types.ClusterStatusCreating, types.ClusterStatusUpdating, types.ClusterStatusPending:
+		report.add("cluster-status", doctorStatusWarn, string(cluster.Status), "Wait for the cluster to become ACTIVE")
+	default:
+		report.add("cluster-status", doctorStatusFail, string(cluster.Status), "The cluster is not usable - check its health in the EKS console")
+		return
+	}
+
+	// certificate authority
+	ca, err := parseClusterCertificateAuthority(cluster)
+	if err != nil {
+		report.add("certificate-authority", doctorStatusFail, err.Error(), "Wait for the cluster to become ACTIVE - the certificate authority returned by DescribeCluster is unusable")
+		return
+	}
+	caSummary := fmt.Sprintf("%s valid until %s ( sha256 %s )", ca.Subject, ca.NotAfter.UTC().Format(time.RFC3339), ca.SHA256)
+	switch {
+	case guardrails.checkCertificateAuthority(ca) != nil:
+		report.add("certificate-authority", doctorStatusFail, caSummary, "The certificate authority does not match --expected-ca-sha256 - check the AWS account and the cluster name")
+		return
+	case time.Now().After(ca.NotAfter):
+		report.add("certificate-authority", doctorStatusWarn, caSummary, "The certificate authority has expired - rotate the cluster certificates")
+	default:
+		report.add("certificate-authority", doctorStatusPass, caSummary, "")
+	}
+	certificateAuthorityData := ca.Data
+
+	// endpoint DNS, TCP and TLS
+	endpoint, err := url.Parse(aws.ToString(cluster.Endpoint))
+	if err != nil {
+		report.add("endpoint-reachability", doctorStatusFail, "cluster has no endpoint yet", "Wait for the cluster to become ACTIVE")
+		return
+	}
+
+	privateOnly := cluster.ResourcesVpcConfig != nil && !cluster.ResourcesVpcConfig.EndpointPublicAccess
+	if err := checkTLSReachability(c.Context, aws.ToString(cluster.Endpoint), certificateAuthorityData); err != nil {
+		hint := "Check DNS, firewall rules and the cluster public access CIDRs"
+		if privateOnly {
+			hint = "The endpoint is private only - connect from within the VPC, over a VPN or through a bastion"
+		}
+		report.add("endpoint-reachability", doctorStatusFail, err.Error(), hint)
+		return
+	}
+	if privateOnly {
+		report.add("endpoint-reachability", doctorStatusWarn, endpoint.Host+" is reachable ( private only endpoint )", "Clients outside the VPC will not be able to reach the cluster")
+	} else {
+		report.add("endpoint-reachability", doctorStatusPass, endpoint.Host+" is reachable", "")
+	}
+
+	// authentication against /version
+	token, err := presignEKSToken(c.Context, getTokenClusterID(cluster, ""))
+	if err != nil {
+		report.add("authentication", doctorStatusFail, err.Error(), "Failed to presign the bearer token - check the credentials")
+		return
+	}
+
+	statusCode, err := getClusterVersionStatus(c.Context, aws.ToString(cluster.Endpoint), certificateAuthorityData, token)
+	switch {
+	case err != nil:
+		report.add("authentication", doctorStatusFail, err.Error(), "Check connectivity towards the cluster endpoint")
+	case statusCode == http.StatusOK:
+		report.add("authentication", doctorStatusPass, "authenticated against /version", "")
+	case statusCode == http.StatusUnauthorized:
+		report.add("authentication", doctorStatusFail, "cluster rejected the token ( 401 )", "Map "+aws.ToString(identity.Arn)+" in the aws-auth ConfigMap or create an EKS access entry for it")
+	default:
+		report.add("authentication", doctorStatusWarn, fmt.Sprintf("unexpected status %d from /version", statusCode), "The identity is authenticated but may lack RBAC permissions")
+	}
+}
+
+// Checks DNS resolution and the TCP connection towards a host on port 443
+func checkTCPReachability(ctx context.Context, host string) error {
+
+	dialer := &net.Dialer{Timeout: doctorDialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(host, "443"))
+	if err != nil {
+		return err
+	}
+
+	return conn.Close()
+}
+
+// Checks DNS resolution, the connection ( through --https-proxy / HTTPS_PROXY ) and the TLS handshake against the cluster certificate authority
+func checkTLSReachability(ctx context.Context, endpoint string, certificateAuthorityData []byte) error {
+
+	rootCAs := x509.NewCertPool()
+	if !rootCAs.AppendCertsFromPEM(certificateAuthorityData) {
+		return fmt.Errorf("failed to parse cluster certificate authority data")
+	}
+
+	client := &http.Client{
+		Timeout: doctorDialTimeout,
+		Transport: &http.Transport{
+			Proxy:           network.proxyFunc(),
+			TLSClientConfig: &tls.Config{RootCAs: rootCAs, MinVersion: tls.VersionTLS12},
+		},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint+"/healthz", nil)
+	if err != nil {
+		return err
+	}
+
+	// Any response - even 401 or 403 - proves the endpoint answered over a verified TLS connection
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	return resp.Body.Close()
+}
+
+// Calls /version on the cluster with the bearer token and returns the HTTP status code
+func getClusterVersionStatus(ctx context.Context, endpoint string, certificateAuthorityData []byte, token string) (int, error) {
+
+	rootCAs := x509.NewCertPool()
+	if !rootCAs.AppendCertsFromPEM(certificateAuthorityData) {
+		return 0, fmt.Errorf("failed to parse cluster certificate authority data")
+	}
+
+	client := &http.Client{
+		Timeout: doctorDialTimeout,
+		Transport: &http.Transport{
+			Proxy:           network.proxyFunc(),
+			TLSClientConfig: &tls.Config{RootCAs: rootCAs, MinVersion: tls.VersionTLS12},
+		},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint+"/version", nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	return resp.StatusCode, nil
+}
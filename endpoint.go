@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/eks/types"
+	"github.com/urfave/cli/v2"
+	"k8s.io/client-go/tools/clientcmd/api"
+)
+
+const (
+	endpointAccessAuto    = "auto"
+	endpointAccessPublic  = "public"
+	endpointAccessPrivate = "private"
+)
+
+// endpointAccess describes how clients reach the API server of the cluster ( directly, through a proxy or a port-forward )
+type endpointAccess struct {
+	Mode          string
+	ProxyURL      string
+	TLSServerName string
+	Server        string
+}
+
+// Returns the flags configuring how the generated kubeconfig reaches the API server
+func endpointAccessFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:  "endpoint-access",
+			Usage: "Endpoint the kubeconfig is meant for: auto ( warns when the public endpoint is disabled ), public ( fails when it is disabled ) or private",
+			Value: endpointAccessAuto,
+		},
+		&cli.StringFlag{
+			Name:     "proxy-url",
+			Usage:    "HTTP(S) or SOCKS5 proxy kubectl reaches the API server through ( e.g. socks5://localhost:1080 )",
+			Required: false,
+		},
+		&cli.StringFlag{
+			Name:     "tls-server-name",
+			Usage:    "Server name used to verify the API server certificate ( defaults to the EKS endpoint host with --server )",
+			Required: false,
+		},
+		&cli.StringFlag{
+			Name:     "server",
+			Usage:    "Overrides the API server address ( e.g. a local port-forward such as https://127.0.0.1:8443 ) keeping TLS verification against the EKS endpoint",
+			Required: false,
+		},
+	}
+}
+
+// Reads the endpoint access flags
+func newEndpointAccess(c *cli.Context) (endpointAccess, error) {
+
+	access := endpointAccess{
+		Mode:          c.String("endpoint-access"),
+		ProxyURL:      c.String("proxy-url"),
+		TLSServerName: c.String("tls-server-name"),
+		Server:        c.String("server"),
+	}
+
+	switch access.Mode {
+	case endpointAccessAuto, endpointAccessPublic, endpointAccessPrivate:
+	default:
+		return access, fmt.Errorf("unsupported endpoint access %q - use %s, %s or %s", access.Mode, endpointAccessAuto, endpointAccessPublic, endpointAccessPrivate)
+	}
+
+	if access.ProxyURL != "" {
+		proxyURL, err := url.Parse(access.ProxyURL)
+		if err != nil {
+			return access, fmt.Errorf("invalid --proxy-url: %w", err)
+		}
+		switch proxyURL.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return access, fmt.Errorf("unsupported --proxy-url scheme %q - use http, https or socks5", proxyURL.Scheme)
+		}
+	}
+
+	if access.Server != "" {
+		serverURL, err := url.Parse(access.Server)
+		if err != nil || serverURL.Scheme != "https" || serverURL.Host == "" {
+			return access, fmt.Errorf("invalid --server %q - expected an https URL", access.Server)
+		}
+	}
+
+	return access, nil
+}
+
+// Checks the endpoint access configuration of the cluster against the requested access
+func (a endpointAccess) check(cluster *types.Cluster) error {
+
+	vpcConfig := cluster.ResourcesVpcConfig
+	if vpcConfig == nil || vpcConfig.EndpointPublicAccess {
+		return nil
+	}
+
+	switch a.Mode {
+	case endpointAccessPublic:
+		return fmt.Errorf("the public endpoint of EKS cluster %s is disabled", aws.ToString(cluster.Name))
+	case endpointAccessAuto:
+		if a.ProxyURL == "" && a.Server == "" {
+			logSugar.Warnw("the public endpoint of the EKS cluster is disabled - the API server is only reachable from within the VPC ( use --proxy-url or --server to go through a bastion )", "cluster", aws.ToString(cluster.Name))
+		}
+	}
+
+	return nil
+}
+
+// Applies the proxy, TLS server name and server override to the generated cluster entry
+func (a endpointAccess) apply(cluster *api.Cluster) {
+
+	if a.ProxyURL != "" {
+		cluster.ProxyURL = a.ProxyURL
+	}
+
+	if a.Server != "" {
+		// The certificate of the API server is issued for the EKS endpoint - keep verifying against it
+		if a.TLSServerName == "" {
+			cluster.TLSServerName = endpointHostname(cluster.Server)
+		}
+		cluster.Server = a.Server
+	}
+
+	if a.TLSServerName != "" {
+		cluster.TLSServerName = a.TLSServerName
+	}
+}
+
+// Returns the hostname of an endpoint URL without the port
+func endpointHostname(endpoint string) string {
+
+	parsedURL, err := url.Parse(endpoint)
+	if err != nil {
+		return endpoint
+	}
+
+	return parsedURL.Hostname()
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+const (
+	errorClassUnexpected      = "unexpected"
+	errorClassExpiredToken    = "expired-token"
+	errorClassAccessDenied    = "access-denied"
+	errorClassClusterNotFound = "cluster-not-found"
+	errorClassRegionDisabled  = "region-disabled"
+	errorClassThrottling      = "throttling"
+	errorClassNetwork         = "network"
+	errorClassGuardrail       = "guardrail-violation"
+	errorClassClusterNotReady = "cluster-not-ready"
+	errorClassTimeout         = "timeout"
+	errorClassCanceled        = "canceled"
+
+	// Exit codes per error class so pipelines can tell failures apart
+	exitCodeUnexpected      = 1
+	exitCodeExpiredToken    = 10
+	exitCodeAccessDenied    = 11
+	exitCodeClusterNotFound = 12
+	exitCodeRegionDisabled  = 13
+	exitCodeThrottling      = 14
+	exitCodeNetwork         = 15
+	exitCodeGuardrail       = 16
+	exitCodeClusterNotReady = 17
+	exitCodeTimeout         = 18
+	exitCodeCanceled        = 130
+)
+
+var (
+	// AWS error codes per error class - throttling and transient codes are added from the AWS SDK defaults in init
+	awsErrorCodeClasses = map[string]string{
+		"ExpiredToken":              errorClassExpiredToken,
+		"ExpiredTokenException":     errorClassExpiredToken,
+		"RequestExpired":            errorClassExpiredToken,
+		"AccessDenied":              errorClassAccessDenied,
+		"AccessDeniedException":     errorClassAccessDenied,
+		"UnauthorizedOperation":     errorClassAccessDenied,
+		"InvalidIdentityToken":      errorClassAccessDenied,
+		"ResourceNotFoundException": errorClassClusterNotFound,
+		"RegionDisabledException":   errorClassRegionDisabled,
+	}
+
+	// Exit code and remediation per error class
+	errorClassDetails = map[string]struct {
+		exitCode    int
+		remediation string
+	}{
+		errorClassUnexpected:      {exitCodeUnexpected, "Run qbconf doctor aws to narrow down the failure"},
+		errorClassExpiredToken:    {exitCodeExpiredToken, "The AWS credentials expired - refresh them ( e.g. aws sso login ) or re-run the job"},
+		errorClassAccessDenied:    {exitCodeAccessDenied, "The identity is not allowed to perform the call - check the IAM policies and the role trust policy"},
+		errorClassClusterNotFound: {exitCodeClusterNotFound, "The EKS cluster does not exist - check the cluster name, the region and the AWS account"},
+		errorClassRegionDisabled:  {exitCodeRegionDisabled, "STS is disabled in this region - activate the region in the IAM account settings"},
+		errorClassThrottling:      {exitCodeThrottling, "AWS throttled the request - retry later or reduce parallel jobs"},
+		errorClassNetwork:         {exitCodeNetwork, "AWS could not be reached - check DNS, firewall rules and proxy settings"},
+		errorClassGuardrail:       {exitCodeGuardrail, "Refusing to issue credentials - check AWS_PROFILE, the role ARN and the cluster name"},
+		errorClassClusterNotReady: {exitCodeClusterNotReady, "The EKS cluster is not ready to be used - wait for it to become ACTIVE ( --wait-for-active )"},
+		errorClassTimeout:         {exitCodeTimeout, "The run exceeded --timeout - check connectivity towards AWS or raise the timeout"},
+		errorClassCanceled:        {exitCodeCanceled, "The run was interrupted"},
+	}
+)
+
+func init() {
+	// Classify every code the AWS SDK retries so the retry policy keeps retrying them
+	for code := range retry.DefaultThrottleErrorCodes {
+		awsErrorCodeClasses[code] = errorClassThrottling
+	}
+	for code := range retry.DefaultRetryableErrorCodes {
+		awsErrorCodeClasses[code] = errorClassNetwork
+	}
+}
+
+// QbconfError is a classified error carrying a remediation hint and a distinct exit code
+type QbconfError struct {
+	Class       string
+	Remediation string
+	Err         error
+	exitCode    int
+}
+
+// Error implements the error interface for QbconfError.
+func (e *QbconfError) Error() string {
+	return redactSecrets(fmt.Sprintf("%s: %s ( %s )", e.Class, e.Err.Error(), e.Remediation))
+}
+
+// Unwrap returns the original error
+func (e *QbconfError) Unwrap() error {
+	return e.Err
+}
+
+// ExitCode implements cli.ExitCoder so the process exits with the code of the error class
+func (e *QbconfError) ExitCode() int {
+	return e.exitCode
+}
+
+// Function to classify an error returned by the AWS SDK into an actionable QbconfError
+func classifyAWSError(err error) error {
+
+	if err == nil {
+		return nil
+	}
+
+	var qbconfErr *QbconfError
+	if errors.As(err, &qbconfErr) {
+		return err
+	}
+
+	class := errorClassUnexpected
+
+	var apiErr smithy.APIError
+	var responseErr *smithyhttp.ResponseError
+	var sendErr *smithyhttp.RequestSendError
+	var netErr net.Error
+
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		class = errorClassTimeout
+	case errors.Is(err, context.Canceled):
+		class = errorClassCanceled
+	case errors.As(err, &apiErr):
+		if apiClass, ok := awsErrorCodeClasses[apiErr.ErrorCode()]; ok {
+			class = apiClass
+		} else if errors.As(err, &responseErr) && responseErr.HTTPStatusCode() == 429 {
+			class = errorClassThrottling
+		}
+	case errors.As(err, &sendErr), errors.As(err, &netErr):
+		class = errorClassNetwork
+	}
+
+	return newQbconfError(class, err)
+}
+
+// Creates a classified error for failures detected by qbconf itself
+func newQbconfError(class string, err error) error {
+	details := errorClassDetails[class]
+	return &QbconfError{
+		Class:       class,
+		Remediation: details.remediation,
+		Err:         err,
+		exitCode:    details.exitCode,
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+
+	"github.com/urfave/cli/v2"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// Function to run a command with a temporary kubeconfig which is removed once the command finished
+func execWithKubeconfig(c *cli.Context) error {
+
+	args := c.Args().Slice()
+	if len(args) == 0 {
+		return fmt.Errorf("no command given - usage: qbconf exec aws [options] -- <command> [args...]")
+	}
+
+	_, err := configureAWSCredentials(c, "exec::aws")
+	if err != nil {
+		return err
+	}
+
+	identity, err := getAWSIdentity(c.Context, *awsConfig)
+	if err != nil {
+		return err
+	}
+
+	cluster, err := getReadyEKSCluster(c.Context, c.String("cluster-name"), c.Bool("wait-for-active"), c.Duration("wait-timeout"), endpointAccess{})
+	if err != nil {
+		return err
+	}
+
+	kubeconfigByteArr, err := generateKubeconfigEKS(c.Context, c.String("region"), cluster, c.String("cluster-id"), authProviderIAM, endpointAccess{})
+	if err != nil {
+		return err
+	}
+
+	err = auditKubeconfig(c, identity, kubeconfigByteArr)
+	if err != nil {
+		return err
+	}
+
+	if c.Bool("gha-integration") && isGithubActions() {
+		config, err := clientcmd.Load(kubeconfigByteArr)
+		if err != nil {
+			return err
+		}
+		maskGithubActionsSecrets(c.Context, config)
+	}
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+
+	kubeconfigPath := ""
+	if c.Bool("in-memory") {
+		memoryFile, err := createMemoryFile("qbconf-kubeconfig", kubeconfigByteArr)
+		if err != nil {
+			return err
+		}
+		defer memoryFile.Close()
+
+		// ExtraFiles start at file descriptor 3 in the child process
+		cmd.ExtraFiles = []*os.File{memoryFile}
+		kubeconfigPath = "/dev/fd/3"
+	} else {
+		tmpFile, err := ioutil.TempFile("", "qbconf-kubeconfig-*.yaml")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmpFile.Name())
+
+		if _, err := tmpFile.Write(kubeconfigByteArr); err != nil {
+			tmpFile.Close()
+			return err
+		}
+		if err := tmpFile.Close(); err != nil {
+			return err
+		}
+		kubeconfigPath = tmpFile.Name()
+	}
+
+	cmd.Env = append(os.Environ(), "KUBECONFIG="+kubeconfigPath)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT)
+	defer signal.Stop(signals)
+
+	logSugar.Infow("running command with temporary kubeconfig", "command", args[0], "kubeconfig", kubeconfigPath)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	go func() {
+		for sig := range signals {
+			logSugar.Infow("forwarding signal to command", "signal", sig.String())
+			cmd.Process.Signal(sig)
+		}
+	}()
+
+	err = cmd.Wait()
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		exitCode := exitErr.ExitCode()
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			exitCode = 128 + int(status.Signal())
+		}
+
+		logSugar.Infow("command exited with non zero status", "command", args[0], "exit_code", exitCode)
+		return cli.Exit("", exitCode)
+	}
+
+	return err
+}
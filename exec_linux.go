@@ -0,0 +1,24 @@
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// Creates an anonymous in-memory file holding the content ( never touches the disk )
+func createMemoryFile(name string, content []byte) (*os.File, error) {
+
+	fd, err := unix.MemfdCreate(name, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	file := os.NewFile(uintptr(fd), name)
+	if _, err := file.Write(content); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return file, nil
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+// In-memory files are only available on linux
+func createMemoryFile(name string, content []byte) (*os.File, error) {
+	return nil, fmt.Errorf("in-memory kubeconfig is not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/clientcmd/api"
+)
+
+var (
+	// Outputs, env and summary are only written once - watch mode keeps masking every new token
+	githubActionsOutputsWritten bool
+)
+
+// Checks if qbconf is running inside a GitHub Actions workflow
+func isGithubActions() bool {
+	return os.Getenv("GITHUB_ACTIONS") == "true"
+}
+
+// Function to integrate a generated kubeconfig with the GitHub Actions runner ( masking, outputs, env and step summary )
+func publishGithubActions(ctx context.Context, kubeconfigPath string, kubeconfigBytes []byte, identity *sts.GetCallerIdentityOutput, roleArn string, exportKubeconfig bool) error {
+
+	config, err := clientcmd.Load(kubeconfigBytes)
+	if err != nil {
+		return err
+	}
+
+	// Masking has to happen before anything else could print the values
+	maskGithubActionsSecrets(ctx, config)
+
+	if githubActionsOutputsWritten {
+		return nil
+	}
+	githubActionsOutputsWritten = true
+
+	absKubeconfigPath, err := filepath.Abs(kubeconfigPath)
+	if err != nil {
+		return err
+	}
+
+	clusterEndpoint, expiration := "", ""
+	if kubeContext, ok := config.Contexts[config.CurrentContext]; ok {
+		if cluster, ok := config.Clusters[kubeContext.Cluster]; ok {
+			clusterEndpoint = cluster.Server
+		}
+		if authInfo, ok := config.AuthInfos[kubeContext.AuthInfo]; ok {
+			if tokenExpiration, err := getTokenExpiration(authInfo.Token); err == nil {
+				expiration = tokenExpiration.Format(time.RFC3339)
+			}
+		}
+	}
+
+	logSugar.Info("writing GitHub Actions step outputs")
+	err = appendGithubActionsFile("GITHUB_OUTPUT", fmt.Sprintf("kubeconfig-path=%s\ncontext=%s\ncluster-endpoint=%s\n",
+		absKubeconfigPath, config.CurrentContext, clusterEndpoint))
+	if err != nil {
+		return err
+	}
+
+	if exportKubeconfig {
+		logSugar.Info("exporting KUBECONFIG to GitHub Actions environment")
+		if err := appendGithubActionsFile("GITHUB_ENV", fmt.Sprintf("KUBECONFIG=%s\n", absKubeconfigPath)); err != nil {
+			return err
+		}
+	}
+
+	callerArn := ""
+	if identity != nil {
+		callerArn = aws.ToString(identity.Arn)
+	}
+
+	summary := strings.Builder{}
+	summary.WriteString("### qbconf kubeconfig\n\n")
+	summary.WriteString("| | |\n|---|---|\n")
+	summary.WriteString(fmt.Sprintf("| Cluster | `%s` |\n", config.CurrentContext))
+	summary.WriteString(fmt.Sprintf("| Endpoint | `%s` |\n", clusterEndpoint))
+	summary.WriteString(fmt.Sprintf("| Role | `%s` |\n", roleArn))
+	summary.WriteString(fmt.Sprintf("| Caller ARN | `%s` |\n", callerArn))
+	summary.WriteString(fmt.Sprintf("| Token expiry | `%s` |\n\n", expiration))
+
+	logSugar.Info("writing GitHub Actions step summary")
+	return appendGithubActionsFile("GITHUB_STEP_SUMMARY", summary.String())
+}
+
+// Masks the bearer tokens of the kubeconfig and the AWS session credentials in the GitHub Actions log
+func maskGithubActionsSecrets(ctx context.Context, config *api.Config) {
+
+	secrets := []string{}
+	for _, authInfo := range config.AuthInfos {
+		secrets = append(secrets, authInfo.Token)
+	}
+	if awsConfig != nil && awsConfig.Credentials != nil {
+		if creds, err := awsConfig.Credentials.Retrieve(ctx); err == nil {
+			secrets = append(secrets, creds.AccessKeyID, creds.SecretAccessKey, creds.SessionToken)
+		}
+	}
+
+	maskGithubActionsValues(os.Stdout, secrets...)
+}
+
+// Prints the workflow command masking the values in the GitHub Actions log
+func maskGithubActionsValues(out io.Writer, values ...string) {
+	for _, value := range values {
+		if value != "" {
+			fmt.Fprintf(out, "::add-mask::%s\n", value)
+		}
+	}
+}
+
+// Appends content to the runner file referenced by the environment variable ( skipped when it is not set )
+func appendGithubActionsFile(envVarName, content string) error {
+
+	path := os.Getenv(envVarName)
+	if path == "" {
+		logSugar.Warnw("GitHub Actions file environment variable not set - skipping", "env_var", envVarName)
+		return nil
+	}
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.WriteString(content)
+	return err
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/urfave/cli/v2"
+)
+
+var (
+	// Guardrails of the current command - enforced before any credentials are issued
+	guardrails Guardrails
+)
+
+// Guardrails restrict the AWS accounts and identities qbconf issues cluster credentials for
+type Guardrails struct {
+	AllowedAccountIDs   []string
+	ForbiddenAccountIDs []string
+	ExpectedCallerArn   string
+	ExpectedCASHA256    string
+}
+
+// Returns the guardrail flags shared by all commands which resolve AWS credentials
+func guardrailFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringSliceFlag{
+			Name:    "allowed-account-ids",
+			Usage:   "AWS account IDs qbconf may issue credentials for ( caller and cluster account )",
+			EnvVars: []string{"QBCONF_ALLOWED_ACCOUNT_IDS"},
+		},
+		&cli.StringSliceFlag{
+			Name:    "forbidden-account-ids",
+			Usage:   "AWS account IDs qbconf must never issue credentials for ( caller and cluster account )",
+			EnvVars: []string{"QBCONF_FORBIDDEN_ACCOUNT_IDS"},
+		},
+		&cli.StringFlag{
+			Name:     "expected-caller-arn",
+			Usage:    "Glob the caller ARN has to match ( e.g. arn:aws:sts::123456789012:assumed-role/Deployer/* )",
+			EnvVars:  []string{"QBCONF_EXPECTED_CALLER_ARN"},
+			Required: false,
+		},
+	}
+}
+
+// Creates the guardrails from the command flags
+func newGuardrails(c *cli.Context) Guardrails {
+	return Guardrails{
+		AllowedAccountIDs:   c.StringSlice("allowed-account-ids"),
+		ForbiddenAccountIDs: c.StringSlice("forbidden-account-ids"),
+		ExpectedCallerArn:   c.String("expected-caller-arn"),
+		ExpectedCASHA256:    c.String("expected-ca-sha256"),
+	}
+}
+
+// Checks the caller identity against the guardrails
+func (g Guardrails) checkIdentity(identity *sts.GetCallerIdentityOutput) error {
+
+	callerArn, account := aws.ToString(identity.Arn), aws.ToString(identity.Account)
+
+	if err := g.checkAccount("caller", account); err != nil {
+		return err
+	}
+
+	if g.ExpectedCallerArn != "" && !matchesWildcard(g.ExpectedCallerArn, callerArn) {
+		return newQbconfError(errorClassGuardrail, fmt.Errorf("caller %s does not match the expected caller ARN %s", callerArn, g.ExpectedCallerArn))
+	}
+
+	return nil
+}
+
+// Checks the account of the cluster ARN against the guardrails
+func (g Guardrails) checkClusterArn(clusterArn string) error {
+
+	// arn:aws:eks:<region>:<account>:cluster/<name>
+	parts := strings.Split(clusterArn, ":")
+	if len(parts) < 6 {
+		return newQbconfError(errorClassGuardrail, fmt.Errorf("unable to determine account of cluster ARN %q", clusterArn))
+	}
+
+	return g.checkAccount("cluster", parts[4])
+}
+
+// Checks an account ID against the allowed and forbidden accounts
+func (g Guardrails) checkAccount(subject, account string) error {
+
+	for _, forbidden := range g.ForbiddenAccountIDs {
+		if account == strings.TrimSpace(forbidden) {
+			return newQbconfError(errorClassGuardrail, fmt.Errorf("%s account %s is forbidden", subject, account))
+		}
+	}
+
+	if len(g.AllowedAccountIDs) == 0 {
+		return nil
+	}
+	for _, allowed := range g.AllowedAccountIDs {
+		if account == strings.TrimSpace(allowed) {
+			return nil
+		}
+	}
+
+	return newQbconfError(errorClassGuardrail, fmt.Errorf("%s account %s is not in the allowed accounts %v", subject, account, g.AllowedAccountIDs))
+}
+
+// Checks the cluster certificate authority against the pinned fingerprint
+func (g Guardrails) checkCertificateAuthority(ca *clusterCertificateAuthority) error {
+
+	if g.ExpectedCASHA256 == "" {
+		return nil
+	}
+
+	if normalizeFingerprint(g.ExpectedCASHA256) != ca.SHA256 {
+		return newQbconfError(errorClassGuardrail, fmt.Errorf("cluster certificate authority %s ( sha256 %s ) does not match the pinned fingerprint %s", ca.Subject, ca.SHA256, g.ExpectedCASHA256))
+	}
+
+	return nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/clientcmd/api"
+)
+
+const (
+	iamAuthenticatorCommand = "aws-iam-authenticator"
+
+	// API version of the client.authentication ExecCredential written to exec entries
+	execCredentialAPIVersion = "client.authentication.k8s.io/v1beta1"
+)
+
+// Function to generate a kubeconfig for a self-managed cluster authenticating with aws-iam-authenticator
+func generateKubeconfigIAMAuthenticator(c *cli.Context) (err error) {
+
+	runResult, err = newRunResult(c, "generate::aws-iam-authenticator")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if resultErr := writeRunResult(c, c.String("cluster-id"), err); resultErr != nil && err == nil {
+			err = resultErr
+		}
+	}()
+
+	qbconfOperationMode, err := configureAWSCredentials(c, "generate::aws-iam-authenticator")
+	if err != nil {
+		return err
+	}
+
+	identity, err := getAWSIdentity(c.Context, *awsConfig)
+	if err != nil {
+		return err
+	}
+	runResult.setIdentity(qbconfOperationMode, identity, getAssumedRoleArn(c))
+
+	certificateAuthorityData, err := loadCertificateAuthorityFlags(c.String("ca-file"), c.String("ca-data"))
+	if err != nil {
+		return err
+	}
+
+	clusterID := c.String("cluster-id")
+	name := c.String("cluster-name")
+	if name == "" {
+		name = clusterID
+	}
+
+	authInfo := &api.AuthInfo{}
+	if c.Bool("exec") {
+		authInfo.Exec = newIAMAuthenticatorExecConfig(clusterID, c.String("region"), c.String("role-arn"), c.Bool("with-assume-role"))
+		if c.Bool("with-gha-oidc") {
+			logSugar.Warn("the exec entry runs aws-iam-authenticator with the default credentials - the GitHub Actions OIDC session is not reused")
+		}
+	} else {
+		authInfo.Token, err = presignEKSToken(c.Context, clusterID)
+		if err != nil {
+			return err
+		}
+	}
+
+	logSugar.Infow("generating kubeconfig for the aws-iam-authenticator cluster ...", "cluster_id", clusterID, "server", c.String("server"))
+	config := newKubeconfig(name,
+		&api.Cluster{
+			Server:                   c.String("server"),
+			CertificateAuthorityData: certificateAuthorityData,
+		},
+		authInfo,
+		Provenance{ClusterType: clusterTypeAWSIAMAuthenticator, Region: c.String("region"), ClusterName: clusterID},
+	)
+
+	kubeconfigByteArr, err := clientcmd.Write(*config)
+	if err != nil {
+		return err
+	}
+
+	return writeKubeconfig(c, kubeconfigByteArr, identity)
+}
+
+// Loads the PEM encoded certificate authority either from a file or from base64 encoded data
+func loadCertificateAuthorityFlags(caFile, caData string) ([]byte, error) {
+
+	switch {
+	case caFile != "" && caData != "":
+		return nil, fmt.Errorf("--ca-file and --ca-data are mutually exclusive")
+	case caFile != "":
+		return os.ReadFile(caFile)
+	case caData != "":
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(caData))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode --ca-data: %w", err)
+		}
+		return decoded, nil
+	default:
+		return nil, fmt.Errorf("either --ca-file or --ca-data is required")
+	}
+}
+
+// Creates the exec entry letting kubectl fetch fresh tokens from aws-iam-authenticator
+func newIAMAuthenticatorExecConfig(clusterID, region, roleArn string, withAssumeRole bool) *api.ExecConfig {
+
+	args := []string{"token", "-i", clusterID}
+	if withAssumeRole && roleArn != "" {
+		args = append(args, "-r", roleArn)
+	}
+
+	return &api.ExecConfig{
+		APIVersion: execCredentialAPIVersion,
+		Command:    iamAuthenticatorCommand,
+		Args:       args,
+		Env: []api.ExecEnvVar{
+			{Name: "AWS_REGION", Value: region},
+		},
+		InteractiveMode: api.NeverExecInteractiveMode,
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/eks"
+	"github.com/aws/aws-sdk-go-v2/service/eks/types"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/clientcmd/api"
+)
+
+const (
+	authProviderIAM  = "iam"
+	authProviderOIDC = "oidc"
+	authProviderBoth = "both"
+
+	// Type of the identity provider configs associated to EKS clusters
+	identityProviderTypeOIDC = "oidc"
+
+	// Name of the kubeconfig extension listing the claims the API server requires from OIDC tokens
+	oidcRequiredClaimsExtensionName = "qbconf.raftech.nl/oidc-required-claims"
+
+	// Suffix of the context and user using the OIDC identity provider when generating both
+	oidcEntrySuffix = "-oidc"
+)
+
+// Checks if the value of --auth-provider is supported
+func validateAuthProvider(authProvider string) error {
+	switch authProvider {
+	case authProviderIAM, authProviderOIDC, authProviderBoth:
+		return nil
+	}
+	return fmt.Errorf("unsupported auth provider %q - use %s, %s or %s", authProvider, authProviderIAM, authProviderOIDC, authProviderBoth)
+}
+
+// Function to fetch the OIDC identity provider config associated to an EKS cluster
+func getEKSOidcIdentityProviderConfig(ctx context.Context, eksClusterName string) (*types.OidcIdentityProviderConfig, error) {
+
+	eksSvc := eks.NewFromConfig(*awsConfig)
+
+	logSugar.Infow("listing identity provider configs of EKS cluster...", "cluster", eksClusterName)
+	res, err := eksSvc.ListIdentityProviderConfigs(ctx, &eks.ListIdentityProviderConfigsInput{
+		ClusterName: aws.String(eksClusterName),
+	})
+	if err != nil {
+		return nil, classifyAWSError(err)
+	}
+
+	for _, providerConfig := range res.IdentityProviderConfigs {
+		if aws.ToString(providerConfig.Type) != identityProviderTypeOIDC {
+			continue
+		}
+
+		logSugar.Infow("describing OIDC identity provider config...", "cluster", eksClusterName, "name", aws.ToString(providerConfig.Name))
+		describeRes, err := eksSvc.DescribeIdentityProviderConfig(ctx, &eks.DescribeIdentityProviderConfigInput{
+			ClusterName: aws.String(eksClusterName),
+			IdentityProviderConfig: &types.IdentityProviderConfig{
+				Name: providerConfig.Name,
+				Type: providerConfig.Type,
+			},
+		})
+		if err != nil {
+			return nil, classifyAWSError(err)
+		}
+
+		oidcConfig := describeRes.IdentityProviderConfig.Oidc
+		if oidcConfig.Status != types.ConfigStatusActive {
+			logSugar.Warnw("OIDC identity provider config is not active", "name", aws.ToString(oidcConfig.IdentityProviderConfigName), "status", oidcConfig.Status)
+		}
+
+		return oidcConfig, nil
+	}
+
+	return nil, fmt.Errorf("EKS cluster %s has no OIDC identity provider associated", eksClusterName)
+}
+
+// Creates a user entry fetching OIDC tokens with kubelogin ( kubectl oidc-login get-token )
+func newOidcExecAuthInfo(oidcConfig *types.OidcIdentityProviderConfig) *api.AuthInfo {
+
+	return &api.AuthInfo{
+		Exec: &api.ExecConfig{
+			APIVersion: execCredentialAPIVersion,
+			Command:    "kubectl",
+			Args: []string{
+				"oidc-login",
+				"get-token",
+				"--oidc-issuer-url=" + aws.ToString(oidcConfig.IssuerUrl),
+				"--oidc-client-id=" + aws.ToString(oidcConfig.ClientId),
+			},
+			InstallHint:     "kubelogin is required to log in through the OIDC identity provider - see https://github.com/int128/kubelogin",
+			InteractiveMode: api.IfAvailableExecInteractiveMode,
+		},
+	}
+}
+
+// Adds the required claims of the identity provider to the extensions of the OIDC user entry
+func addOidcRequiredClaimsExtension(authInfo *api.AuthInfo, requiredClaims map[string]string) {
+
+	if len(requiredClaims) == 0 {
+		return
+	}
+
+	raw, _ := json.Marshal(requiredClaims)
+	if authInfo.Extensions == nil {
+		authInfo.Extensions = map[string]runtime.Object{}
+	}
+	authInfo.Extensions[oidcRequiredClaimsExtensionName] = &runtime.Unknown{Raw: raw, ContentType: runtime.ContentTypeJSON}
+}
+
+// Adds a context and user named <cluster>-oidc using the OIDC identity provider next to the IAM entries
+func addOidcContext(config *api.Config, clusterName string, authInfo *api.AuthInfo, provenance Provenance) {
+
+	name := clusterName + oidcEntrySuffix
+
+	authInfo.Extensions = newProvenanceExtensions(provenance)
+	config.AuthInfos[name] = authInfo
+	config.Contexts[name] = &api.Context{
+		Cluster:    clusterName,
+		Namespace:  "default",
+		AuthInfo:   name,
+		Extensions: newProvenanceExtensions(provenance),
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	// Audience of the projected service account tokens exchanged with STS
+	irsaAudience = "sts.amazonaws.com"
+)
+
+// irsaTrustPolicy is the IAM trust policy allowing a service account to assume a role through the cluster OIDC issuer
+type irsaTrustPolicy struct {
+	Version   string                     `json:"Version"`
+	Statement []irsaTrustPolicyStatement `json:"Statement"`
+}
+
+// irsaTrustPolicyStatement is the single statement of an IRSA trust policy
+type irsaTrustPolicyStatement struct {
+	Effect    string                       `json:"Effect"`
+	Principal map[string]string            `json:"Principal"`
+	Action    string                       `json:"Action"`
+	Condition map[string]map[string]string `json:"Condition"`
+}
+
+// Function to print the IRSA trust policy for a service account of an EKS cluster
+func irsaTrustPolicyAWS(c *cli.Context, out io.Writer) error {
+
+	_, err := configureAWSCredentials(c, "irsa::trust-policy")
+	if err != nil {
+		return err
+	}
+
+	identity, err := getAWSIdentity(c.Context, *awsConfig)
+	if err != nil {
+		return err
+	}
+
+	cluster, err := describeEKSCluster(c.Context, c.String("cluster-name"))
+	if err != nil {
+		return err
+	}
+
+	if cluster.Identity == nil || cluster.Identity.Oidc == nil || aws.ToString(cluster.Identity.Oidc.Issuer) == "" {
+		return fmt.Errorf("EKS cluster %s has no OIDC issuer", c.String("cluster-name"))
+	}
+
+	partition := "aws"
+	if callerArn, err := arn.Parse(aws.ToString(identity.Arn)); err == nil {
+		partition = callerArn.Partition
+	}
+
+	issuer := strings.TrimPrefix(aws.ToString(cluster.Identity.Oidc.Issuer), "https://")
+	providerArn := fmt.Sprintf("arn:%s:iam::%s:oidc-provider/%s", partition, aws.ToString(identity.Account), issuer)
+
+	if c.Bool("check-provider") {
+		err = checkIAMOidcProvider(c.Context, providerArn)
+		if err != nil {
+			return err
+		}
+	}
+
+	policy := newIRSATrustPolicy(providerArn, issuer, c.String("namespace"), c.String("service-account"))
+
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(policy)
+}
+
+// Builds the trust policy restricting the role to a single service account
+func newIRSATrustPolicy(providerArn, issuer, namespace, serviceAccount string) irsaTrustPolicy {
+
+	return irsaTrustPolicy{
+		Version: "2012-10-17",
+		Statement: []irsaTrustPolicyStatement{
+			{
+				Effect:    "Allow",
+				Principal: map[string]string{"Federated": providerArn},
+				Action:    "sts:AssumeRoleWithWebIdentity",
+				Condition: map[string]map[string]string{
+					"StringEquals": {
+						issuer + ":aud": irsaAudience,
+						issuer + ":sub": fmt.Sprintf("system:serviceaccount:%s:%s", namespace, serviceAccount),
+					},
+				},
+			},
+		},
+	}
+}
+
+// Checks that the IAM OIDC provider for the cluster issuer exists and trusts the STS audience
+func checkIAMOidcProvider(ctx context.Context, providerArn string) error {
+
+	logSugar.Infow("checking IAM OIDC provider...", "provider_arn", providerArn)
+
+	iamSvc := iam.NewFromConfig(*awsConfig)
+	res, err := iamSvc.GetOpenIDConnectProvider(ctx, &iam.GetOpenIDConnectProviderInput{
+		OpenIDConnectProviderArn: aws.String(providerArn),
+	})
+	if err != nil {
+		var notFoundErr *types.NoSuchEntityException
+		if errors.As(err, &notFoundErr) {
+			return fmt.Errorf("IAM OIDC provider %s does not exist - create it for the cluster issuer first", providerArn)
+		}
+		return classifyAWSError(err)
+	}
+
+	for _, clientID := range res.ClientIDList {
+		if clientID == irsaAudience {
+			logSugar.Infow("IAM OIDC provider exists", "provider_arn", providerArn)
+			return nil
+		}
+	}
+
+	logSugar.Warnw("IAM OIDC provider does not list the STS audience", "provider_arn", providerArn, "audience", irsaAudience)
+	return nil
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/clientcmd/api"
+)
+
+const (
+	// Name of the kubeconfig extension carrying the provenance of qbconf generated entries
+	provenanceExtensionName = "qbconf.raftech.nl/provenance"
+	provenanceManagedBy     = "qbconf"
+
+	clusterTypeEKS                 = "eks"
+	clusterTypeAWSIAMAuthenticator = "aws-iam-authenticator"
+)
+
+var (
+	// Matches EKS API server hostnames such as ABCDEF.gr7.eu-west-1.eks.amazonaws.com
+	eksEndpointRegionRegexp = regexp.MustCompile(`\.([a-z]{2}(?:-[a-z]+)+-\d)\.eks\.amazonaws\.com(?:\.cn)?$`)
+)
+
+// Provenance describes which qbconf run generated a kubeconfig entry
+type Provenance struct {
+	ManagedBy   string `json:"managedBy"`
+	Version     string `json:"version,omitempty"`
+	RequestUUID string `json:"requestUuid,omitempty"`
+	ClusterType string `json:"clusterType,omitempty"`
+	Region      string `json:"region,omitempty"`
+	ClusterName string `json:"clusterName,omitempty"`
+	ClusterArn  string `json:"clusterArn,omitempty"`
+	GeneratedAt string `json:"generatedAt,omitempty"`
+}
+
+// Creates the provenance extensions attached to every kubeconfig entry qbconf generates
+func newProvenanceExtensions(provenance Provenance) map[string]runtime.Object {
+
+	provenance.ManagedBy = provenanceManagedBy
+	provenance.Version = version
+	provenance.RequestUUID = reqUuid
+	provenance.GeneratedAt = time.Now().UTC().Format(time.RFC3339)
+
+	raw, _ := json.Marshal(provenance)
+
+	return map[string]runtime.Object{
+		provenanceExtensionName: &runtime.Unknown{Raw: raw, ContentType: runtime.ContentTypeJSON},
+	}
+}
+
+// Builds a kubeconfig holding a single cluster, user and context named after the cluster
+func newKubeconfig(name string, cluster *api.Cluster, authInfo *api.AuthInfo, provenance Provenance) *api.Config {
+
+	cluster.Extensions = newProvenanceExtensions(provenance)
+	authInfo.Extensions = newProvenanceExtensions(provenance)
+
+	return &api.Config{
+		Clusters: map[string]*api.Cluster{
+			name: cluster,
+		},
+		Contexts: map[string]*api.Context{
+			name: {
+				Cluster:    name,
+				Namespace:  "default",
+				AuthInfo:   name,
+				Extensions: newProvenanceExtensions(provenance),
+			},
+		},
+		AuthInfos: map[string]*api.AuthInfo{
+			name: authInfo,
+		},
+		CurrentContext: name,
+	}
+}
+
+// Reads the qbconf provenance from kubeconfig entry extensions ( nil when the entry was not generated by qbconf )
+func getProvenance(extensions map[string]runtime.Object) *Provenance {
+
+	ext, ok := extensions[provenanceExtensionName]
+	if !ok {
+		return nil
+	}
+
+	unknown, ok := ext.(*runtime.Unknown)
+	if !ok {
+		return nil
+	}
+
+	var provenance Provenance
+	if err := json.Unmarshal(unknown.Raw, &provenance); err != nil {
+		return nil
+	}
+	if provenance.ManagedBy != provenanceManagedBy {
+		return nil
+	}
+
+	return &provenance
+}
+
+// Checks if a kubeconfig context was generated by qbconf ( provenance metadata or a presigned STS token )
+func isQbconfManaged(config *api.Config, contextName string) bool {
+
+	kubeContext, ok := config.Contexts[contextName]
+	if !ok {
+		return false
+	}
+	if getProvenance(kubeContext.Extensions) != nil {
+		return true
+	}
+
+	if authInfo, ok := config.AuthInfos[kubeContext.AuthInfo]; ok {
+		if getProvenance(authInfo.Extensions) != nil || strings.HasPrefix(authInfo.Token, v1Prefix) {
+			return true
+		}
+	}
+
+	if cluster, ok := config.Clusters[kubeContext.Cluster]; ok {
+		return getProvenance(cluster.Extensions) != nil
+	}
+
+	return false
+}
+
+// Computes the expiry of a k8s-aws-v1. token from the X-Amz-Date of the presigned STS url
+func getTokenExpiration(token string) (time.Time, error) {
+
+	if !strings.HasPrefix(token, v1Prefix) {
+		return time.Time{}, fmt.Errorf("token is missing the %s prefix", v1Prefix)
+	}
+
+	presignedURL, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(token, v1Prefix))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to decode token: %w", err)
+	}
+
+	parsedURL, err := url.Parse(string(presignedURL))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to parse presigned url: %w", err)
+	}
+
+	amzDate := parsedURL.Query().Get("X-Amz-Date")
+	if amzDate == "" {
+		return time.Time{}, fmt.Errorf("presigned url is missing X-Amz-Date")
+	}
+
+	signedAt, err := time.Parse(dateHeaderFormat, amzDate)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to parse X-Amz-Date: %w", err)
+	}
+
+	return signedAt.Add(presignedURLExpiration), nil
+}
+
+// Derives the AWS region of an EKS cluster from its API server endpoint
+func getRegionFromEndpoint(server string) string {
+
+	parsedURL, err := url.Parse(server)
+	if err != nil {
+		return ""
+	}
+
+	matches := eksEndpointRegionRegexp.FindStringSubmatch(strings.ToLower(parsedURL.Hostname()))
+	if matches == nil {
+		return ""
+	}
+
+	return matches[1]
+}
+
+// Removes a context and the cluster / user entries which are no longer referenced afterwards
+func removeContext(config *api.Config, contextName string) (removedClusters, removedAuthInfos []string) {
+
+	kubeContext, ok := config.Contexts[contextName]
+	if !ok {
+		return nil, nil
+	}
+
+	delete(config.Contexts, contextName)
+	if config.CurrentContext == contextName {
+		config.CurrentContext = ""
+	}
+
+	clusterInUse, authInfoInUse := false, false
+	for _, other := range config.Contexts {
+		clusterInUse = clusterInUse || other.Cluster == kubeContext.Cluster
+		authInfoInUse = authInfoInUse || other.AuthInfo == kubeContext.AuthInfo
+	}
+
+	if _, ok := config.Clusters[kubeContext.Cluster]; ok && !clusterInUse {
+		delete(config.Clusters, kubeContext.Cluster)
+		removedClusters = append(removedClusters, kubeContext.Cluster)
+	}
+	if _, ok := config.AuthInfos[kubeContext.AuthInfo]; ok && !authInfoInUse {
+		delete(config.AuthInfos, kubeContext.AuthInfo)
+		removedAuthInfos = append(removedAuthInfos, kubeContext.AuthInfo)
+	}
+
+	return removedClusters, removedAuthInfos
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/urfave/cli/v2"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"golang.org/x/term"
+)
+
+const (
+	logFormatJSON    = "json"
+	logFormatConsole = "console"
+)
+
+// Returns the global flags configuring the logger
+func loggingFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:    "log-level",
+			Usage:   "Minimum level of the logs ( debug, info, warn or error )",
+			EnvVars: []string{"QBCONF_LOG_LEVEL"},
+			Value:   "info",
+		},
+		&cli.StringFlag{
+			Name:    "log-format",
+			Usage:   "Format of the logs ( json or console - defaults to console on a terminal and json otherwise )",
+			EnvVars: []string{"QBCONF_LOG_FORMAT"},
+		},
+		&cli.StringFlag{
+			Name:    "log-file",
+			Usage:   "Writes the logs to a file instead of stderr",
+			EnvVars: []string{"QBCONF_LOG_FILE"},
+		},
+		&cli.BoolFlag{
+			Name:    "quiet",
+			Usage:   "Only logs errors",
+			EnvVars: []string{"QBCONF_QUIET"},
+			Value:   false,
+		},
+	}
+}
+
+// Function to replace the default logger according to the logging flags
+func configureLogger(c *cli.Context) error {
+
+	level := c.String("log-level")
+	if c.Bool("quiet") {
+		level = "error"
+	}
+
+	newLogger, err := buildLogger(level, c.String("log-format"), c.String("log-file"))
+	if err != nil {
+		return err
+	}
+
+	logger = newLogger
+	logSugar = logger.Sugar()
+
+	return nil
+}
+
+// Builds a logger writing in the given format and level to stderr or a file
+func buildLogger(level, format, file string) (*zap.Logger, error) {
+
+	zapLevel, err := zapcore.ParseLevel(level)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --log-level %q - use debug, info, warn or error", level)
+	}
+
+	isTerminal := file == "" && term.IsTerminal(int(os.Stderr.Fd()))
+	if format == "" {
+		format = logFormatJSON
+		if isTerminal {
+			format = logFormatConsole
+		}
+	}
+
+	var loggerConfig zap.Config
+	switch format {
+	case logFormatJSON:
+		loggerConfig = zap.NewProductionConfig()
+	case logFormatConsole:
+		loggerConfig = zap.NewDevelopmentConfig()
+		loggerConfig.Development = false
+		loggerConfig.DisableStacktrace = true
+		loggerConfig.EncoderConfig.EncodeTime = zapcore.TimeEncoderOfLayout("15:04:05")
+		if isTerminal {
+			loggerConfig.EncoderConfig.EncodeLevel = zapcore.CapitalColorLevelEncoder
+		}
+	default:
+		return nil, fmt.Errorf("invalid --log-format %q - use %s or %s", format, logFormatJSON, logFormatConsole)
+	}
+
+	loggerConfig.Level = zap.NewAtomicLevelAt(zapLevel)
+	if file != "" {
+		loggerConfig.OutputPaths = []string{file}
+	}
+
+	return loggerConfig.Build(zap.Fields(zap.String("request_uuid", reqUuid)), zap.WrapCore(newRedactingCore))
+}
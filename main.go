@@ -3,13 +3,14 @@ package main
 import (
 	"context"
 	"encoding/base64"
-	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
-	"math"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/go-resty/resty/v2"
@@ -20,8 +21,8 @@ import (
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
 	"github.com/aws/aws-sdk-go-v2/service/eks"
+	"github.com/aws/aws-sdk-go-v2/service/eks/types"
 	"github.com/aws/aws-sdk-go-v2/service/sts"
-	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/urfave/cli/v2"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/client-go/tools/clientcmd/api"
@@ -62,7 +63,7 @@ func init() {
 
 	reqUuid = uuid.New().String()
 
-	logger, _ = zap.NewProduction(zap.Fields(zap.String("request_uuid", reqUuid)))
+	logger, _ = zap.NewProduction(zap.Fields(zap.String("request_uuid", reqUuid)), zap.WrapCore(newRedactingCore))
 	defer logger.Sync() // flushes buffer, if any
 	logSugar = logger.Sugar()
 }
@@ -80,6 +81,17 @@ func main() {
 	}
 	app.Name = "qbconf"
 	app.Usage = "Minimalistic Kubernetes kubeconfig file generator using AWS STS and EKS APIs"
+	app.Flags = append(loggingFlags(), rootContextFlags()...)
+	app.Before = func(c *cli.Context) error {
+		if err := configureLogger(c); err != nil {
+			return err
+		}
+		return configureRootContext(c)
+	}
+	app.After = func(c *cli.Context) error {
+		cancelRootContext()
+		return nil
+	}
 
 	app.Commands = []*cli.Command{
 		{
@@ -89,32 +101,94 @@ func main() {
 				{
 					Name:  "aws",
 					Usage: "Generate a kubeconfig file for an EKS cluster",
-					Flags: []cli.Flag{
+					Flags: append(append(append(awsCredentialFlags(), eksClusterFlags()...), endpointAccessFlags()...),
 						&cli.StringFlag{
-							Name:     "role-arn",
-							Usage:    "ARN of the AWS IAM role to assume",
-							EnvVars:  []string{"AWS_ROLE_ARN"},
-							Value:    "",
+							Name:     "cluster-name",
+							Usage:    "Name of the EKS cluster to generate a kubeconfig",
+							Required: true,
+						},
+						&cli.StringFlag{
+							Name:     "output-file",
+							Usage:    "Name of the file to write the generated kubeconfig to",
+							Value:    "kubeconfig.yaml",
 							Required: false,
 						},
+						&cli.BoolFlag{
+							Name:  "merge",
+							Usage: "Merges the generated entries into the existing output file instead of overwriting it",
+							Value: false,
+						},
+						&cli.StringFlag{
+							Name:  "auth-provider",
+							Usage: "Authentication of the generated user: iam ( presigned token ), oidc ( kubelogin via the cluster OIDC identity provider ) or both",
+							Value: authProviderIAM,
+						},
+						&cli.BoolFlag{
+							Name:  "watch",
+							Usage: "Keeps running in the foreground and rewrites the kubeconfig before the token expires",
+							Value: false,
+						},
+						&cli.BoolFlag{
+							Name:  "gha-export-kubeconfig",
+							Usage: "Exports KUBECONFIG pointing at the output file to the following GitHub Actions steps",
+							Value: false,
+						},
+						resultFileFlag(),
+						&cli.DurationFlag{
+							Name:  "refresh-interval",
+							Usage: "Interval at which the kubeconfig is regenerated in watch mode",
+							Value: 10 * time.Minute,
+						},
+					),
+					Before: loadAWSConfigBeforeAction,
+					Action: func(c *cli.Context) error {
+
+						if c.Bool("watch") {
+							return watchKubeconfigEKS(c)
+						}
+
+						err := generateKubeconfigAWS(c)
+						if err != nil {
+							logSugar.Error(err)
+							return err
+						}
+
+						return nil
+					},
+				},
+				{
+					Name:  "aws-iam-authenticator",
+					Usage: "Generate a kubeconfig file for a self-managed cluster using aws-iam-authenticator",
+					Flags: append(awsCredentialFlags(),
+						&cli.StringFlag{
+							Name:     "server",
+							Usage:    "URL of the kubernetes API server",
+							Required: true,
+						},
+						&cli.StringFlag{
+							Name:     "cluster-id",
+							Usage:    "Cluster ID configured in aws-iam-authenticator ( the token audience )",
+							Required: true,
+						},
 						&cli.StringFlag{
-							Name:     "region",
-							Usage:    "AWS region",
-							EnvVars:  []string{"AWS_REGION"},
-							Value:    "eu-west-1",
+							Name:     "ca-file",
+							Usage:    "Path of the PEM encoded certificate authority of the API server",
 							Required: false,
 						},
 						&cli.StringFlag{
-							Name:     "role-session-name",
-							Usage:    "Name of the AWS STS role session to create",
-							EnvVars:  []string{"AWS_ROLE_SESSION_NAME"},
-							Value:    "qbconf-session",
+							Name:     "ca-data",
+							Usage:    "Base64 encoded PEM certificate authority of the API server",
 							Required: false,
 						},
 						&cli.StringFlag{
 							Name:     "cluster-name",
-							Usage:    "Name of the EKS cluster to generate a kubeconfig",
-							Required: true,
+							Usage:    "Name of the cluster, user and context in the kubeconfig ( defaults to the cluster ID )",
+							Required: false,
+						},
+						&cli.BoolFlag{
+							Name:  "exec",
+							Usage: "Writes an exec entry calling aws-iam-authenticator instead of a static token",
+							Value: false,
 						},
 						&cli.StringFlag{
 							Name:     "output-file",
@@ -123,98 +197,200 @@ func main() {
 							Required: false,
 						},
 						&cli.BoolFlag{
-							Name:  "with-assume-role",
-							Usage: "Enables assuming of IAM role via STS",
+							Name:  "merge",
+							Usage: "Merges the generated entries into the existing output file instead of overwriting it",
 							Value: false,
 						},
 						&cli.BoolFlag{
-							Name:  "with-gha-oidc",
-							Usage: "Enables assuming of IAM role via OIDC",
+							Name:  "gha-export-kubeconfig",
+							Usage: "Exports KUBECONFIG pointing at the output file to the following GitHub Actions steps",
 							Value: false,
 						},
-					},
-					Before: func(c *cli.Context) error {
-						awsConfigErr = error(nil)
-
-						awsConfig, awsConfigErr = loadAWSConfig(c.String("region"))
+						resultFileFlag(),
+					),
+					Before: loadAWSConfigBeforeAction,
+					Action: func(c *cli.Context) error {
 
-						if awsConfigErr != nil {
-							logSugar.Error(awsConfigErr)
-							return awsConfigErr
+						err := generateKubeconfigIAMAuthenticator(c)
+						if err != nil {
+							logSugar.Error(err)
+							return err
 						}
 
-						logSugar.Debug("loaded default AWS config successfully")
-						return awsConfigErr
+						return nil
 					},
+				},
+			},
+			Action: func(c *cli.Context) error {
+				cli.ShowSubcommandHelp(c)
+				return nil
+			},
+		},
+		{
+			Name:  "doctor",
+			Usage: "Diagnose why a kubeconfig can not be generated or used",
+			Subcommands: []*cli.Command{
+				{
+					Name:  "aws",
+					Usage: "Check credentials, STS, EKS and the cluster endpoint step by step",
+					Flags: append(awsCredentialFlags(),
+						&cli.StringFlag{
+							Name:     "cluster-name",
+							Usage:    "Name of the EKS cluster to diagnose",
+							Required: true,
+						},
+						&cli.StringFlag{
+							Name:     "expected-ca-sha256",
+							Usage:    "SHA-256 fingerprint the cluster certificate authority has to match",
+							EnvVars:  []string{"QBCONF_EXPECTED_CA_SHA256"},
+							Required: false,
+						},
+						&cli.StringFlag{
+							Name:     "output",
+							Usage:    "Output format ( text or json )",
+							Value:    "text",
+							Required: false,
+						},
+					),
+					Action: func(c *cli.Context) error {
+						return doctorAWS(c, os.Stdout)
+					},
+				},
+			},
+			Action: func(c *cli.Context) error {
+				cli.ShowSubcommandHelp(c)
+				return nil
+			},
+		},
+		{
+			Name:  "irsa",
+			Usage: "Helpers for IAM roles for service accounts",
+			Subcommands: []*cli.Command{
+				{
+					Name:  "trust-policy",
+					Usage: "Print the IAM trust policy allowing a service account of an EKS cluster to assume a role",
+					Flags: append(awsCredentialFlags(),
+						&cli.StringFlag{
+							Name:     "cluster-name",
+							Usage:    "Name of the EKS cluster running the service account",
+							Required: true,
+						},
+						&cli.StringFlag{
+							Name:     "namespace",
+							Usage:    "Namespace of the service account",
+							Required: true,
+						},
+						&cli.StringFlag{
+							Name:     "service-account",
+							Usage:    "Name of the service account",
+							Required: true,
+						},
+						&cli.BoolFlag{
+							Name:  "check-provider",
+							Usage: "Checks that the IAM OIDC provider for the cluster issuer exists",
+							Value: false,
+						},
+					),
+					Before: loadAWSConfigBeforeAction,
 					Action: func(c *cli.Context) error {
 
-						qbconfOperationMode := "generate::aws::with-default-credentials"
-						logSugar.Infow("set default operating mode",
-							"mode", qbconfOperationMode,
-						)
-
-						if c.Bool("with-assume-role") {
-							qbconfOperationMode = "generate::aws::with-assume-role"
-
-							logSugar.Infow("change operating mode",
-								"mode", qbconfOperationMode,
-							)
-
-							provider := assumeRoleByArn(c.String("role-arn"), c.String("role-session-name"), awsConfig)
-							awsConfig.Credentials = provider
+						err := irsaTrustPolicyAWS(c, os.Stdout)
+						if err != nil {
+							logSugar.Error(err)
+							return err
 						}
-						if c.Bool("with-gha-oidc") {
-							qbconfOperationMode = "generate::aws::with-gha-oidc"
-
-							logSugar.Infow("change operating mode",
-								"mode", qbconfOperationMode,
-							)
-
-							// Add your own configuration here
-							maxRetries := 3
-							backoffBaseSeconds := 2
 
-							assumeRoleWithWebIdentityErr := retryWithExponentialBackoff(maxRetries, backoffBaseSeconds, func() error {
-								var err error
-
-								oidcToken, err := getOidcGithubActionsToken()
-								if err != nil {
-									return err
-								}
-
-								awsConfig.Credentials, err = assumeRoleWithWebIdentity(c.String("role-arn"), c.String("role-session-name"), *oidcToken, awsConfig)
-								return err
-							})
-
-							if assumeRoleWithWebIdentityErr != nil {
-								logSugar.Error(assumeRoleWithWebIdentityErr)
-								return assumeRoleWithWebIdentityErr
-							}
-
-							// OidcToken, OidcTokenErr := getOidcGithubActionsToken()
-							// if OidcTokenErr != nil {
-							// 	logSugar.Error(OidcTokenErr)
-							// 	return OidcTokenErr
-							// }
+						return nil
+					},
+				},
+			},
+			Action: func(c *cli.Context) error {
+				cli.ShowSubcommandHelp(c)
+				return nil
+			},
+		},
+		{
+			Name:  "exec",
+			Usage: "Run a command with a temporary kubeconfig for a kubernetes cluster",
+			Subcommands: []*cli.Command{
+				{
+					Name:      "aws",
+					Usage:     "Run a command with a temporary kubeconfig for an EKS cluster",
+					ArgsUsage: "-- <command> [args...]",
+					Flags: append(append(awsCredentialFlags(), eksClusterFlags()...),
+						&cli.StringFlag{
+							Name:     "cluster-name",
+							Usage:    "Name of the EKS cluster to generate a kubeconfig",
+							Required: true,
+						},
+						&cli.BoolFlag{
+							Name:  "in-memory",
+							Usage: "Passes the kubeconfig as an in-memory file descriptor instead of a temp file ( linux only )",
+							Value: false,
+						},
+					),
+					Before: loadAWSConfigBeforeAction,
+					Action: func(c *cli.Context) error {
 
-							// awsConfig.Credentials = assumeRoleWithWebIdentity(c.String("role-arn"), c.String("role-session-name"), *OidcToken, awsConfig)
+						err := execWithKubeconfig(c)
+						if _, isExitCoder := err.(cli.ExitCoder); err != nil && !isExitCoder {
+							logSugar.Error(err)
 						}
 
-						_, getAWSIdentityErr := getAWSIdentity(*awsConfig)
-						if getAWSIdentityErr != nil {
-							logSugar.Error(getAWSIdentityErr)
-							return getAWSIdentityErr
-						}
+						return err
+					},
+				},
+			},
+			Action: func(c *cli.Context) error {
+				cli.ShowSubcommandHelp(c)
+				return nil
+			},
+		},
+		{
+			Name:  "proxy",
+			Usage: "Run a local authenticating proxy towards a kubernetes cluster",
+			Subcommands: []*cli.Command{
+				{
+					Name:  "aws",
+					Usage: "Run a local proxy injecting fresh bearer tokens into requests towards an EKS cluster",
+					Flags: append(append(awsCredentialFlags(), eksClusterFlags()...),
+						&cli.StringFlag{
+							Name:     "cluster-name",
+							Usage:    "Name of the EKS cluster to proxy requests to",
+							Required: true,
+						},
+						&cli.StringFlag{
+							Name:     "listen",
+							Usage:    "Address the proxy listens on",
+							Value:    "127.0.0.1:8001",
+							Required: false,
+						},
+						&cli.StringFlag{
+							Name:     "tls-cert-file",
+							Usage:    "Certificate to serve HTTPS with ( serves plain HTTP when empty )",
+							Required: false,
+						},
+						&cli.StringFlag{
+							Name:     "tls-key-file",
+							Usage:    "Private key matching the certificate in --tls-cert-file",
+							Required: false,
+						},
+						&cli.StringFlag{
+							Name:     "output-file",
+							Usage:    "Name of the file to write the kubeconfig pointing at the proxy to",
+							Value:    "kubeconfig.yaml",
+							Required: false,
+						},
+					),
+					Before: loadAWSConfigBeforeAction,
+					Action: func(c *cli.Context) error {
 
-						kubeconfigByteArr, errKubeConfig := generateKubeconfigEKS(c.String("region"), c.String("cluster-name"))
-						if errKubeConfig != nil {
-							logSugar.Error(errKubeConfig)
-							return errKubeConfig
+						err := proxyEKS(c)
+						if err != nil {
+							logSugar.Error(err)
+							return err
 						}
 
-						logSugar.Infow("writing kubeconfig to file", "file", c.String("output-file"))
-						writeToFile(c.String("output-file"), kubeconfigByteArr)
-
 						return nil
 					},
 				},
@@ -224,20 +400,319 @@ func main() {
 				return nil
 			},
 		},
+		{
+			Name:  "prune",
+			Usage: "Remove expired or orphaned qbconf managed entries from a kubeconfig file",
+			Flags: append([]cli.Flag{
+				&cli.StringFlag{
+					Name:     "kubeconfig",
+					Usage:    "Path of the kubeconfig file to prune",
+					EnvVars:  []string{qbconfKubeconfigEnvVarName},
+					Value:    "kubeconfig.yaml",
+					Required: false,
+				},
+				&cli.BoolFlag{
+					Name:  "dry-run",
+					Usage: "Only print the entries which would be removed",
+					Value: false,
+				},
+				&cli.BoolFlag{
+					Name:  "check-clusters",
+					Usage: "Removes entries of EKS clusters which no longer exist ( calls DescribeCluster using default credentials )",
+					Value: false,
+				},
+			}, append(networkFlags(), retryFlags()...)...),
+			Action: func(c *cli.Context) error {
+
+				network = newNetworkSettings(c)
+				retryPolicy = newRetryPolicy(c)
+
+				err := pruneKubeconfig(c.Context, c.String("kubeconfig"), c.Bool("dry-run"), c.Bool("check-clusters"))
+				if err != nil {
+					logSugar.Error(err)
+					return err
+				}
+
+				return nil
+			},
+		},
+		{
+			Name:  "status",
+			Usage: "Show the endpoint, auth style and token expiry of every context in a kubeconfig file",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:     "kubeconfig",
+					Usage:    "Path of the kubeconfig file to inspect",
+					EnvVars:  []string{qbconfKubeconfigEnvVarName},
+					Value:    "kubeconfig.yaml",
+					Required: false,
+				},
+				&cli.StringFlag{
+					Name:     "output",
+					Usage:    "Output format ( table or json )",
+					Value:    "table",
+					Required: false,
+				},
+				&cli.DurationFlag{
+					Name:  "expiry-threshold",
+					Usage: "Exit with code 3 when the token of the current context expires within this duration",
+					Value: 1 * time.Minute,
+				},
+			},
+			Action: func(c *cli.Context) error {
+				return kubeconfigStatus(os.Stdout, c.String("kubeconfig"), c.String("output"), c.Duration("expiry-threshold"))
+			},
+		},
+	}
+
+	// The root context is cancelled on SIGINT / SIGTERM so no call can block a pipeline forever
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	err := app.RunContext(ctx, os.Args)
+	if err != nil {
+		log.Fatal(redactSecrets(err.Error()))
+	}
+}
+
+// Returns the flags shared by all commands which resolve AWS credentials
+func awsCredentialFlags() []cli.Flag {
+	flags := []cli.Flag{
+		&cli.StringFlag{
+			Name:     "role-arn",
+			Usage:    "ARN of the AWS IAM role to assume",
+			EnvVars:  []string{"AWS_ROLE_ARN"},
+			Value:    "",
+			Required: false,
+		},
+		&cli.StringFlag{
+			Name:     "region",
+			Usage:    "AWS region",
+			EnvVars:  []string{"AWS_REGION"},
+			Value:    "eu-west-1",
+			Required: false,
+		},
+		&cli.BoolFlag{
+			Name:  "with-assume-role",
+			Usage: "Enables assuming of IAM role via STS",
+			Value: false,
+		},
+		&cli.BoolFlag{
+			Name:  "with-gha-oidc",
+			Usage: "Enables assuming of IAM role via OIDC",
+			Value: false,
+		},
+		&cli.BoolFlag{
+			Name:  "debug-oidc",
+			Usage: "Prints the claims of the GitHub Actions OIDC token",
+			Value: false,
+		},
+		&cli.BoolFlag{
+			Name:  "gha-integration",
+			Usage: "Masks secrets and writes step outputs / summary when running in GitHub Actions",
+			Value: true,
+		},
+	}
+
+	return append(append(append(append(append(flags, roleSessionFlags()...), guardrailFlags()...), networkFlags()...), retryFlags()...), auditFlags()...)
+}
+
+// Loads the default AWS config for commands using the shared AWS credential flags
+func loadAWSConfigBeforeAction(c *cli.Context) error {
+	awsConfigErr = configureAWSCommand(c)
+	if awsConfigErr != nil {
+		logSugar.Error(awsConfigErr)
+		return awsConfigErr
+	}
+
+	awsConfig, awsConfigErr = loadAWSConfig(c.Context, c.String("region"))
+
+	if awsConfigErr != nil {
+		logSugar.Error(awsConfigErr)
+		return awsConfigErr
+	}
+
+	logSugar.Debug("loaded default AWS config successfully")
+	return awsConfigErr
+}
+
+// Applies the shared AWS credential flags ( guardrails, network, retries, audit log and role session ) to the current command
+func configureAWSCommand(c *cli.Context) error {
+	guardrails = newGuardrails(c)
+	network = newNetworkSettings(c)
+	retryPolicy = newRetryPolicy(c)
+	auditLog = newAuditLog(c)
+
+	var err error
+	roleSession, err = newRoleSession(c)
+	return err
+}
+
+// Configures the credentials of the global AWS config for the requested operating mode
+func configureAWSCredentials(c *cli.Context, command string) (string, error) {
+
+	qbconfOperationMode := command + "::with-default-credentials"
+	logSugar.Infow("set default operating mode",
+		"mode", qbconfOperationMode,
+	)
+
+	if c.Bool("with-assume-role") {
+		qbconfOperationMode = command + "::with-assume-role"
+
+		logSugar.Infow("change operating mode",
+			"mode", qbconfOperationMode,
+			"role_session_name", roleSession.Name,
+			"source_identity", roleSession.SourceIdentity,
+		)
+
+		provider := assumeRoleByArn(c.String("role-arn"), roleSession, awsConfig)
+		awsConfig.Credentials = aws.NewCredentialsCache(provider)
+	}
+	if c.Bool("with-gha-oidc") {
+		qbconfOperationMode = command + "::with-gha-oidc"
+
+		logSugar.Infow("change operating mode",
+			"mode", qbconfOperationMode,
+			"role_session_name", roleSession.Name,
+		)
+
+		// Keep the default credentials around - they may allow reading the role trust policy when assuming fails
+		defaultConfig := awsConfig.Copy()
+
+		// AssumeRoleWithWebIdentity is retried by the AWS SDK - only the token request needs the retry policy here
+		var oidcToken *string
+		err := retryPolicy.do(c.Context, "request GitHub OIDC token", func() error {
+			var err error
+			oidcToken, err = getOidcGithubActionsToken(c.Context)
+			return err
+		})
+		if err != nil {
+			return qbconfOperationMode, err
+		}
+
+		if c.Bool("debug-oidc") {
+			logOidcClaims(*oidcToken)
+		}
+
+		awsConfig.Credentials, err = assumeRoleWithWebIdentity(c.Context, c.String("role-arn"), roleSession.Name, *oidcToken, awsConfig)
+		if err != nil {
+			if isAccessDeniedError(err) {
+				explainWebIdentityFailure(c.Context, defaultConfig, c.String("role-arn"), *oidcToken)
+			}
+			return qbconfOperationMode, err
+		}
+	}
+
+	return qbconfOperationMode, nil
+}
+
+// Generates the kubeconfig for an EKS cluster and writes it to the output file
+func generateKubeconfigAWS(c *cli.Context) (err error) {
+
+	runResult, err = newRunResult(c, "generate::aws")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if resultErr := writeRunResult(c, c.String("cluster-name"), err); resultErr != nil && err == nil {
+			err = resultErr
+		}
+	}()
+
+	err = validateAuthProvider(c.String("auth-provider"))
+	if err != nil {
+		return err
+	}
+
+	access, err := newEndpointAccess(c)
+	if err != nil {
+		return err
+	}
+
+	qbconfOperationMode, err := configureAWSCredentials(c, "generate::aws")
+	if err != nil {
+		return err
+	}
+
+	identity, err := getAWSIdentity(c.Context, *awsConfig)
+	if err != nil {
+		return err
+	}
+	runResult.setIdentity(qbconfOperationMode, identity, getAssumedRoleArn(c))
+
+	cluster, err := getReadyEKSCluster(c.Context, c.String("cluster-name"), c.Bool("wait-for-active"), c.Duration("wait-timeout"), access)
+	if err != nil {
+		return err
+	}
+
+	err = access.check(cluster)
+	if err != nil {
+		return err
+	}
+
+	kubeconfigByteArr, err := generateKubeconfigEKS(c.Context, c.String("region"), cluster, c.String("cluster-id"), c.String("auth-provider"), access)
+	if err != nil {
+		return err
+	}
+
+	return writeKubeconfig(c, kubeconfigByteArr, identity)
+}
+
+// Publishes the generated kubeconfig to GitHub Actions and writes ( or merges ) it to the output file
+func writeKubeconfig(c *cli.Context, kubeconfigByteArr []byte, identity *sts.GetCallerIdentityOutput) error {
+
+	var err error
+	generatedByteArr := kubeconfigByteArr
+
+	if c.Bool("gha-integration") && isGithubActions() {
+		err = publishGithubActions(c.Context, c.String("output-file"), kubeconfigByteArr, identity, getAssumedRoleArn(c), c.Bool("gha-export-kubeconfig"))
+		if err != nil {
+			return err
+		}
 	}
 
-	err := app.Run(os.Args)
+	// Record the issued credentials before they are handed out
+	err = auditKubeconfig(c, identity, generatedByteArr)
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+
+	if c.Bool("merge") {
+		kubeconfigByteArr, err = mergeKubeconfig(c.String("output-file"), kubeconfigByteArr)
+		if err != nil {
+			return err
+		}
 	}
+
+	logSugar.Infow("writing kubeconfig to file", "file", c.String("output-file"))
+	err = writeToFile(c.String("output-file"), kubeconfigByteArr)
+	if err != nil {
+		return err
+	}
+
+	runResult.addKubeconfig(c.String("output-file"), generatedByteArr)
+	return nil
+}
+
+// Returns the ARN of the role assumed in the current operating mode ( empty for default credentials )
+func getAssumedRoleArn(c *cli.Context) string {
+	if c.Bool("with-assume-role") || c.Bool("with-gha-oidc") {
+		return c.String("role-arn")
+	}
+	return ""
 }
 
 // Loads the default AWS configuration - accordingly to the SDK documentation of resolving credentials
-func loadAWSConfig(region string) (*aws.Config, error) {
+func loadAWSConfig(ctx context.Context, region string) (*aws.Config, error) {
 
 	logSugar.Info("Loading default AWS config...")
 
-	cfg, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion(region))
+	networkOptions, err := network.awsConfigOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx, append(networkOptions, config.WithRegion(region), config.WithRetryer(retryPolicy.newAWSRetryer))...)
 	if err != nil {
 		logSugar.Errorw("failed to load SDK config", err)
 		return nil, err
@@ -246,24 +721,39 @@ func loadAWSConfig(region string) (*aws.Config, error) {
 	return &cfg, nil
 }
 
-// Gets the current identity which we have from AWS
-func getAWSIdentity(cfg aws.Config) (*sts.GetCallerIdentityOutput, error) {
+// Gets the current identity which we have from AWS and checks it against the guardrails
+func getAWSIdentity(ctx context.Context, cfg aws.Config) (*sts.GetCallerIdentityOutput, error) {
+
+	result, err := getCallerIdentity(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := guardrails.checkIdentity(result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// Gets the current identity which we have from AWS without applying the guardrails
+func getCallerIdentity(ctx context.Context, cfg aws.Config) (*sts.GetCallerIdentityOutput, error) {
 
 	logSugar.Info("Getting AWS identity... (getAWSIdentity)")
 
 	svc := sts.NewFromConfig(cfg)
 
 	input := &sts.GetCallerIdentityInput{}
-	result, err := svc.GetCallerIdentity(context.TODO(), input)
+	result, err := svc.GetCallerIdentity(ctx, input)
 	if err != nil {
-		var aerr awserr.Error
-		if ok := errors.As(err, &aerr); ok {
-			switch aerr.Code() {
-			default:
-				return nil, fmt.Errorf("aws error: %s", aerr.Error())
-			}
-		} else {
-			return nil, fmt.Errorf("unexpected error: %s", err.Error())
+		return nil, classifyAWSError(err)
+	}
+
+	// The credentials are cached by now - make sure they never show up in logs
+	if cfg.Credentials != nil {
+		if creds, err := cfg.Credentials.Retrieve(ctx); err == nil {
+			registerSecret(creds.SecretAccessKey)
+			registerSecret(creds.SessionToken)
 		}
 	}
 
@@ -277,21 +767,19 @@ func getAWSIdentity(cfg aws.Config) (*sts.GetCallerIdentityOutput, error) {
 }
 
 // Function to assume a role by ARN provided
-func assumeRoleByArn(roleArn, roleSessionName string, awsConfig *aws.Config) *stscreds.AssumeRoleProvider {
+func assumeRoleByArn(roleArn string, session RoleSession, awsConfig *aws.Config) *stscreds.AssumeRoleProvider {
 
 	// Create an STS client using the default config
 	stsClient := sts.NewFromConfig(*awsConfig)
 
 	// Create an AssumeRoleProvider that will assume the specified role
-	roleProvider := stscreds.NewAssumeRoleProvider(stsClient, roleArn, func(o *stscreds.AssumeRoleOptions) {
-		o.RoleSessionName = roleSessionName
-	})
+	roleProvider := stscreds.NewAssumeRoleProvider(stsClient, roleArn, session.applyAssumeRoleOptions)
 
 	return roleProvider
 }
 
 // Function to assume role with OIDC ( token )
-func assumeRoleWithWebIdentity(roleArn, roleSessionName, token string, awsConfig *aws.Config) (*aws.CredentialsCache, error) {
+func assumeRoleWithWebIdentity(ctx context.Context, roleArn, roleSessionName, token string, awsConfig *aws.Config) (*aws.CredentialsCache, error) {
 
 	// Create an STS client using the default config
 	stsClient := sts.NewFromConfig(*awsConfig)
@@ -304,9 +792,9 @@ func assumeRoleWithWebIdentity(roleArn, roleSessionName, token string, awsConfig
 	}
 
 	// Call the AssumeRoleWithWebIdentity API to assume the IAM role
-	resp, err := stsClient.AssumeRoleWithWebIdentity(context.Background(), input)
+	resp, err := stsClient.AssumeRoleWithWebIdentity(ctx, input)
 	if err != nil {
-		return nil, err
+		return nil, classifyAWSError(err)
 	}
 
 	// value := aws.Credentials{
@@ -318,6 +806,9 @@ func assumeRoleWithWebIdentity(roleArn, roleSessionName, token string, awsConfig
 	// 	Expires:         *resp.Credentials.Expiration,
 	// }
 
+	registerSecret(aws.ToString(resp.Credentials.SecretAccessKey))
+	registerSecret(aws.ToString(resp.Credentials.SessionToken))
+
 	credsProvider := aws.NewCredentialsCache(
 		credentials.NewStaticCredentialsProvider(
 			*resp.Credentials.AccessKeyId,
@@ -329,8 +820,8 @@ func assumeRoleWithWebIdentity(roleArn, roleSessionName, token string, awsConfig
 	return credsProvider, nil
 }
 
-// Function to generate a kubeconfig for a given EKS cluster
-func generateKubeconfigEKS(region, eksClusterName string) ([]byte, error) {
+// Function to create a k8s-aws-v1. bearer token for a given EKS cluster ( presigned STS GetCallerIdentity url )
+func presignEKSToken(ctx context.Context, clusterID string) (string, error) {
 
 	stsSvc := sts.NewFromConfig(*awsConfig)
 
@@ -340,54 +831,89 @@ func generateKubeconfigEKS(region, eksClusterName string) ([]byte, error) {
 	}))
 
 	logSugar.Info("calling PresignGetCallerIdentity ...")
-	getCallerIdentity, err := presignClient.PresignGetCallerIdentity(context.Background(), &sts.GetCallerIdentityInput{}, func(presignOptions *sts.PresignOptions) {
+	getCallerIdentity, err := presignClient.PresignGetCallerIdentity(ctx, &sts.GetCallerIdentityInput{}, func(presignOptions *sts.PresignOptions) {
 		presignOptions.ClientOptions = append(presignOptions.ClientOptions, func(stsOptions *sts.Options) {
 			// Add clusterId Header
-			stsOptions.APIOptions = append(stsOptions.APIOptions, smithyhttp.SetHeaderValue(clusterIDHeader, eksClusterName))
+			stsOptions.APIOptions = append(stsOptions.APIOptions, smithyhttp.SetHeaderValue(clusterIDHeader, clusterID))
 			// Add back useless X-Amz-Expires query param
 			stsOptions.APIOptions = append(stsOptions.APIOptions, smithyhttp.SetHeaderValue("X-Amz-Expires", requestPresignParam))
 		})
 	})
 
 	if err != nil {
-		return nil, err
+		return "", classifyAWSError(err)
 	}
 
+	token := v1Prefix + base64.RawURLEncoding.EncodeToString([]byte(getCallerIdentity.URL))
+	registerSecret(token)
+
+	return token, nil
+}
+
+// Function to describe a given EKS cluster
+func describeEKSCluster(ctx context.Context, eksClusterName string) (*types.Cluster, error) {
+
 	logSugar.Info("cretaing new EKS client...")
 	eksSvc := eks.NewFromConfig(*awsConfig)
 
 	logSugar.Info("describing EKS cluster...")
-	res, err := eksSvc.DescribeCluster(context.TODO(), &eks.DescribeClusterInput{
+	res, err := eksSvc.DescribeCluster(ctx, &eks.DescribeClusterInput{
 		Name: aws.String(eksClusterName),
 	})
+	if err != nil {
+		return nil, classifyAWSError(err)
+	}
+
+	if err := guardrails.checkClusterArn(aws.ToString(res.Cluster.Arn)); err != nil {
+		return nil, err
+	}
+
+	return res.Cluster, nil
+}
+
+// Function to generate a kubeconfig for a given EKS cluster using IAM, its OIDC identity provider or both
+func generateKubeconfigEKS(ctx context.Context, region string, cluster *types.Cluster, clusterIDOverride, authProvider string, access endpointAccess) ([]byte, error) {
+
+	logSugar.Info("validating certificateAuthorityData...")
+	ca, err := parseClusterCertificateAuthority(cluster)
 	if err != nil {
 		return nil, err
 	}
+	logCertificateAuthority(ca)
 
-	logSugar.Info("decoding certificateAuthorityData...")
-	certificateAuthorityData, _ := base64.StdEncoding.DecodeString(*res.Cluster.CertificateAuthority.Data)
+	clusterEntry := &api.Cluster{
+		Server:                   *cluster.Endpoint,
+		CertificateAuthorityData: ca.Data,
+	}
+	access.apply(clusterEntry)
+	provenance := Provenance{ClusterType: clusterTypeEKS, Region: region, ClusterName: *cluster.Name, ClusterArn: aws.ToString(cluster.Arn)}
 
-	logSugar.Info("generating kubeconfig for the EKS cluster ...")
-	config := &api.Config{
-		Clusters: map[string]*api.Cluster{
-			*res.Cluster.Name: {
-				Server:                   *res.Cluster.Endpoint,
-				CertificateAuthorityData: []byte(certificateAuthorityData),
-			},
-		},
-		Contexts: map[string]*api.Context{
-			*res.Cluster.Name: {
-				Cluster:   *res.Cluster.Name,
-				Namespace: "default",
-				AuthInfo:  *res.Cluster.Name,
-			},
-		},
-		AuthInfos: map[string]*api.AuthInfo{
-			*res.Cluster.Name: {
-				Token: v1Prefix + base64.RawURLEncoding.EncodeToString([]byte(getCallerIdentity.URL)),
-			},
-		},
-		CurrentContext: *res.Cluster.Name,
+	var config *api.Config
+
+	if authProvider != authProviderOIDC {
+		token, err := presignEKSToken(ctx, getTokenClusterID(cluster, clusterIDOverride))
+		if err != nil {
+			return nil, err
+		}
+
+		logSugar.Info("generating kubeconfig for the EKS cluster ...")
+		config = newKubeconfig(*cluster.Name, clusterEntry, &api.AuthInfo{Token: token}, provenance)
+	}
+
+	if authProvider == authProviderOIDC || authProvider == authProviderBoth {
+		oidcConfig, err := getEKSOidcIdentityProviderConfig(ctx, *cluster.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		authInfo := newOidcExecAuthInfo(oidcConfig)
+		if config == nil {
+			logSugar.Info("generating OIDC kubeconfig for the EKS cluster ...")
+			config = newKubeconfig(*cluster.Name, clusterEntry, authInfo, provenance)
+		} else {
+			addOidcContext(config, *cluster.Name, authInfo, provenance)
+		}
+		addOidcRequiredClaimsExtension(authInfo, oidcConfig.RequiredClaims)
 	}
 
 	logSugar.Info("output kubeconfig byte[]")
@@ -406,14 +932,28 @@ func maskString(s string) string {
 	return s[:4] + strings.Repeat("*", len(s)-8) + s[len(s)-4:]
 }
 
+// Writes the file atomically ( temp file in the same directory renamed over the target )
 func writeToFile(outputPath string, configBytes []byte) error {
 
-	err := ioutil.WriteFile(outputPath, configBytes, 0644)
+	tmpFile, err := ioutil.TempFile(filepath.Dir(outputPath), "."+filepath.Base(outputPath)+".tmp-*")
 	if err != nil {
-		panic(err)
+		return err
 	}
+	defer os.Remove(tmpFile.Name())
 
-	return nil
+	if _, err := tmpFile.Write(configBytes); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Chmod(0644); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile.Name(), outputPath)
 }
 
 // MissingEnvVarError is a custom error type for missing environment variables.
@@ -423,10 +963,10 @@ type MissingEnvVarError struct {
 
 // Error implements the error interface for MissingEnvVarError.
 func (e MissingEnvVarError) Error() string {
-	return fmt.Sprintf("missing required environment variable: %s", e.EnvVarName)
+	return fmt.Sprintf("missing required environment variable: %s ( the workflow needs the id-token: write permission )", e.EnvVarName)
 }
 
-func getOidcGithubActionsToken() (*string, error) {
+func getOidcGithubActionsToken(ctx context.Context) (*string, error) {
 
 	// These environment variables are required for this action to run.
 	// They will be available only if the workflow calling the action/CLI will have
@@ -438,20 +978,19 @@ func getOidcGithubActionsToken() (*string, error) {
 		if _, exists := os.LookupEnv(envVar); !exists {
 			err := MissingEnvVarError{EnvVarName: envVar}
 			logSugar.Error(err)
-			os.Exit(1)
+			return nil, err
 		}
 	}
 
 	logSugar.Debug("creating new resty client instance...")
 
-	client := resty.New()
-	client.
-		// Set retry count to non zero to enable retries
-		SetRetryCount(3).
-		// override initial retry wait time.
-		SetRetryWaitTime(2 * time.Second).
-		// MaxWaitTime can be overridden as well.
-		SetRetryMaxWaitTime(10 * time.Second)
+	httpClient, err := network.newHTTPClient()
+	if err != nil {
+		return nil, err
+	}
+
+	// Retries are handled by the retry policy of the caller
+	client := resty.NewWithClient(httpClient)
 
 	logSugar.Info("created new resty client")
 
@@ -467,12 +1006,14 @@ func getOidcGithubActionsToken() (*string, error) {
 	//ACTIONS_ID_TOKEN_REQUEST_TOKEN
 	logSugar.Debug("retrieval of ACTIONS_ID_TOKEN_REQUEST_TOKEN env variable")
 	tokenRequestToken := os.Getenv("ACTIONS_ID_TOKEN_REQUEST_TOKEN")
+	registerSecret(tokenRequestToken)
 	logSugar.Info("retrieved ACTIONS_ID_TOKEN_REQUEST_TOKEN")
 
 	logSugar.Debugw("prepared URL for requesting token value towards OIDC endpoint",
 		"oidc_token_request_url", "%s&audience=sts.amazonaws.com",
 	)
 	resp, err := client.R().
+		SetContext(ctx).
 		SetAuthToken(tokenRequestToken).
 		Get(fmt.Sprintf("%s&audience=sts.amazonaws.com", tokenRequestURL))
 
@@ -480,24 +1021,12 @@ func getOidcGithubActionsToken() (*string, error) {
 		logSugar.Error("failed to retrieve token value from OIDC endpoint", err)
 		return nil, err
 	}
+	if resp.IsError() {
+		return nil, &httpStatusError{StatusCode: resp.StatusCode(), Status: resp.Status()}
+	}
 
 	tokenValue := gjson.Get(resp.String(), "value").String()
+	registerSecret(tokenValue)
 
 	return &tokenValue, nil
 }
-
-func retryWithExponentialBackoff(maxRetries int, backoffBaseSeconds int, operation func() error) error {
-	var err error
-	for i := 0; i < maxRetries; i++ {
-		err = operation()
-		if err == nil {
-			return nil
-		}
-
-		waitTime := time.Duration(math.Pow(float64(backoffBaseSeconds), float64(i))) * time.Second
-		logSugar.Infow("retry wait added", "wait_time", waitTime, "attempt", i, "max_retries", maxRetries)
-		time.Sleep(waitTime)
-	}
-
-	return err
-}
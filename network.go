@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/net/http/httpproxy"
+)
+
+var (
+	// Network settings of the current command - applied to AWS, the GitHub OIDC token request and cluster calls
+	network NetworkSettings
+)
+
+// NetworkSettings holds the proxy and CA bundle used for all outbound HTTP
+type NetworkSettings struct {
+	CABundle   string
+	HTTPSProxy string
+	NoProxy    string
+}
+
+// Returns the flags configuring outbound HTTP
+func networkFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:    "ca-bundle",
+			Usage:   "PEM file with additional certificate authorities to trust for AWS and GitHub ( e.g. of a TLS-intercepting proxy )",
+			EnvVars: []string{"AWS_CA_BUNDLE"},
+		},
+		&cli.StringFlag{
+			Name:    "https-proxy",
+			Usage:   "Proxy for all outbound HTTPS requests ( defaults to HTTPS_PROXY )",
+			EnvVars: []string{"QBCONF_HTTPS_PROXY"},
+		},
+		&cli.StringFlag{
+			Name:    "no-proxy",
+			Usage:   "Comma separated hosts, domains and CIDRs which bypass --https-proxy ( defaults to NO_PROXY )",
+			EnvVars: []string{"QBCONF_NO_PROXY"},
+		},
+	}
+}
+
+// Reads the network flags of the current command
+func newNetworkSettings(c *cli.Context) NetworkSettings {
+	return NetworkSettings{
+		CABundle:   c.String("ca-bundle"),
+		HTTPSProxy: c.String("https-proxy"),
+		NoProxy:    c.String("no-proxy"),
+	}
+}
+
+// Returns the proxy selection function - the explicit proxy settings take precedence over the environment
+func (n NetworkSettings) proxyFunc() func(*http.Request) (*url.URL, error) {
+
+	if n.HTTPSProxy == "" && n.NoProxy == "" {
+		return http.ProxyFromEnvironment
+	}
+
+	proxyConfig := httpproxy.FromEnvironment()
+	if n.HTTPSProxy != "" {
+		proxyConfig.HTTPSProxy = n.HTTPSProxy
+		proxyConfig.HTTPProxy = n.HTTPSProxy
+	}
+	if n.NoProxy != "" {
+		proxyConfig.NoProxy = n.NoProxy
+	}
+
+	proxyFunc := proxyConfig.ProxyFunc()
+	return func(req *http.Request) (*url.URL, error) {
+		return proxyFunc(req.URL)
+	}
+}
+
+// Returns the system certificate authorities extended with the CA bundle ( nil when no bundle is configured )
+func (n NetworkSettings) rootCAs() (*x509.CertPool, error) {
+
+	if n.CABundle == "" {
+		return nil, nil
+	}
+
+	bundle, err := os.ReadFile(n.CABundle)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+	}
+
+	rootCAs, err := x509.SystemCertPool()
+	if err != nil {
+		rootCAs = x509.NewCertPool()
+	}
+	if !rootCAs.AppendCertsFromPEM(bundle) {
+		return nil, fmt.Errorf("CA bundle %s holds no PEM certificates", n.CABundle)
+	}
+
+	return rootCAs, nil
+}
+
+// Returns the options applying the proxy and CA bundle to the AWS SDK clients
+func (n NetworkSettings) awsConfigOptions() ([]func(*config.LoadOptions) error, error) {
+
+	options := []func(*config.LoadOptions) error{
+		config.WithHTTPClient(awshttp.NewBuildableClient().WithTransportOptions(func(transport *http.Transport) {
+			transport.Proxy = n.proxyFunc()
+		})),
+	}
+
+	if n.CABundle != "" {
+		bundle, err := os.ReadFile(n.CABundle)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+		}
+		options = append(options, config.WithCustomCABundle(bytes.NewReader(bundle)))
+	}
+
+	return options, nil
+}
+
+// Creates the HTTP client for requests outside of the AWS SDK ( e.g. the GitHub OIDC token request )
+func (n NetworkSettings) newHTTPClient() (*http.Client, error) {
+
+	rootCAs, err := n.rootCAs()
+	if err != nil {
+		return nil, err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = n.proxyFunc()
+	if rootCAs != nil {
+		transport.TLSClientConfig = &tls.Config{RootCAs: rootCAs, MinVersion: tls.VersionTLS12}
+	}
+
+	return &http.Client{Transport: transport}, nil
+}
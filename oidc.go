@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/smithy-go"
+)
+
+var (
+	// Claims of the GitHub Actions OIDC token relevant for role trust policies
+	githubOidcClaimNames = []string{"iss", "aud", "sub", "repository", "ref", "environment", "job_workflow_ref"}
+)
+
+// trustPolicyDocument is the subset of an IAM role trust policy needed to explain web identity failures
+type trustPolicyDocument struct {
+	Statement []trustPolicyStatement `json:"Statement"`
+}
+
+// trustPolicyStatement is a single statement of an IAM role trust policy
+type trustPolicyStatement struct {
+	Effect    string                              `json:"Effect"`
+	Action    stringOrSlice                       `json:"Action"`
+	Condition map[string]map[string]stringOrSlice `json:"Condition"`
+}
+
+// stringOrSlice unmarshals IAM policy values which are either a single string or a list of strings
+type stringOrSlice []string
+
+// Implements json.Unmarshaler
+func (s *stringOrSlice) UnmarshalJSON(data []byte) error {
+
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*s = []string{single}
+		return nil
+	}
+
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return err
+	}
+	*s = multiple
+
+	return nil
+}
+
+// Decodes the claims of a JWT without verifying its signature ( diagnostics only )
+func decodeJWTClaims(token string) (map[string]interface{}, error) {
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("token is not a JWT")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode JWT payload: %w", err)
+	}
+
+	claims := map[string]interface{}{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("failed to parse JWT claims: %w", err)
+	}
+
+	return claims, nil
+}
+
+// Returns the values of a claim as strings ( aud may be a string or a list )
+func getClaimValues(claims map[string]interface{}, name string) []string {
+
+	switch value := claims[name].(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		values := []string{}
+		for _, item := range value {
+			values = append(values, fmt.Sprint(item))
+		}
+		return values
+	case nil:
+		return nil
+	default:
+		return []string{fmt.Sprint(value)}
+	}
+}
+
+// Logs the claims of the GitHub Actions OIDC token relevant for role trust policies
+func logOidcClaims(token string) {
+
+	claims, err := decodeJWTClaims(token)
+	if err != nil {
+		logSugar.Warnw("failed to decode OIDC token claims", "error", err)
+		return
+	}
+
+	keysAndValues := []interface{}{}
+	for _, name := range githubOidcClaimNames {
+		keysAndValues = append(keysAndValues, name, strings.Join(getClaimValues(claims, name), ","))
+	}
+
+	logSugar.Infow("OIDC token claims", keysAndValues...)
+}
+
+// Checks if the error returned by AWS is an AccessDenied error
+func isAccessDeniedError(err error) bool {
+
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.ErrorCode() == "AccessDenied" || apiErr.ErrorCode() == "AccessDeniedException"
+	}
+
+	return false
+}
+
+// Function to explain why assuming a role with web identity was denied by comparing the token claims with the trust policy
+func explainWebIdentityFailure(ctx context.Context, cfg aws.Config, roleArn, token string) {
+
+	logOidcClaims(token)
+
+	claims, err := decodeJWTClaims(token)
+	if err != nil {
+		return
+	}
+
+	document, err := getRoleTrustPolicy(ctx, cfg, roleArn)
+	if err != nil {
+		logSugar.Warnw("unable to fetch the role trust policy - compare the claims above with it manually", "role_arn", roleArn, "error", err)
+		return
+	}
+
+	issuerHost := ""
+	if issuer, err := url.Parse(strings.Join(getClaimValues(claims, "iss"), "")); err == nil {
+		issuerHost = issuer.Host
+	}
+
+	mismatches := findTrustPolicyMismatches(document, issuerHost, claims)
+	if len(mismatches) == 0 {
+		logSugar.Warnw("the OIDC token claims match the trust policy conditions - check the OIDC provider audience and the role permissions boundary", "role_arn", roleArn)
+		return
+	}
+
+	for _, mismatch := range mismatches {
+		logSugar.Errorw("OIDC token claim does not match the role trust policy", "role_arn", roleArn, "mismatch", mismatch)
+	}
+}
+
+// Fetches and decodes the trust policy of an IAM role
+func getRoleTrustPolicy(ctx context.Context, cfg aws.Config, roleArn string) (*trustPolicyDocument, error) {
+
+	roleName := roleArn[strings.LastIndex(roleArn, "/")+1:]
+
+	iamSvc := iam.NewFromConfig(cfg)
+	res, err := iamSvc.GetRole(ctx, &iam.GetRoleInput{
+		RoleName: aws.String(roleName),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	rawDocument, err := url.QueryUnescape(aws.ToString(res.Role.AssumeRolePolicyDocument))
+	if err != nil {
+		return nil, err
+	}
+
+	document := &trustPolicyDocument{}
+	if err := json.Unmarshal([]byte(rawDocument), document); err != nil {
+		return nil, err
+	}
+
+	return document, nil
+}
+
+// Lists the StringEquals / StringLike conditions on the issuer claims which the token does not satisfy
+func findTrustPolicyMismatches(document *trustPolicyDocument, issuerHost string, claims map[string]interface{}) []string {
+
+	mismatches := []string{}
+	for _, statement := range document.Statement {
+		if statement.Effect != "Allow" || !matchesAnyCondition([]string{"sts:AssumeRoleWithWebIdentity"}, statement.Action, true) {
+			continue
+		}
+
+		operators := make([]string, 0, len(statement.Condition))
+		for operator := range statement.Condition {
+			operators = append(operators, operator)
+		}
+		sort.Strings(operators)
+
+		for _, operator := range operators {
+			matchLike := strings.HasSuffix(operator, "StringLike")
+			if !matchLike && !strings.HasSuffix(operator, "StringEquals") {
+				continue
+			}
+
+			keys := make([]string, 0, len(statement.Condition[operator]))
+			for key := range statement.Condition[operator] {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+
+			for _, key := range keys {
+				if issuerHost == "" || !strings.HasPrefix(key, issuerHost+":") {
+					continue
+				}
+
+				expected := statement.Condition[operator][key]
+				actual := getClaimValues(claims, strings.TrimPrefix(key, issuerHost+":"))
+				if !matchesAnyCondition(actual, expected, matchLike) {
+					mismatches = append(mismatches, fmt.Sprintf("%s %s expects %q but token has %q", operator, key, []string(expected), actual))
+				}
+			}
+		}
+	}
+
+	return mismatches
+}
+
+// Checks if any of the actual values matches any of the expected condition values
+func matchesAnyCondition(actual, expected []string, matchLike bool) bool {
+
+	for _, value := range actual {
+		for _, pattern := range expected {
+			if value == pattern || (matchLike && matchesWildcard(pattern, value)) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Matches a value against an IAM StringLike pattern ( * matches any sequence, ? a single character )
+func matchesWildcard(pattern, value string) bool {
+
+	expression := regexp.QuoteMeta(pattern)
+	expression = strings.ReplaceAll(expression, `\*`, ".*")
+	expression = strings.ReplaceAll(expression, `\?`, ".")
+
+	matched, err := regexp.MatchString("^"+expression+"$", value)
+	return err == nil && matched
+}
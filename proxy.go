@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/urfave/cli/v2"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/clientcmd/api"
+)
+
+const (
+	// Tokens are refreshed once they have less than this validity left
+	tokenRefreshMargin = 5 * time.Minute
+	// Time given to in-flight requests when the proxy shuts down
+	proxyShutdownTimeout = 10 * time.Second
+)
+
+// eksTokenSource hands out a cached bearer token and presigns a new one before it expires
+type eksTokenSource struct {
+	mu         sync.Mutex
+	token      string
+	expiration time.Time
+	refresh    func() (string, error)
+}
+
+// Returns a token which is valid for at least the refresh margin
+func (s *eksTokenSource) Token() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token != "" && time.Until(s.expiration) > tokenRefreshMargin {
+		return s.token, nil
+	}
+
+	logSugar.Info("refreshing EKS bearer token ...")
+	token, err := s.refresh()
+	if err != nil {
+		return "", err
+	}
+
+	expiration, err := getTokenExpiration(token)
+	if err != nil {
+		return "", err
+	}
+
+	s.token, s.expiration = token, expiration
+	logSugar.Infow("refreshed EKS bearer token", "expiration", expiration)
+
+	return s.token, nil
+}
+
+// Function to run a local proxy which authenticates requests towards an EKS cluster
+func proxyEKS(c *cli.Context) error {
+
+	listenAddr := c.String("listen")
+	tlsCertFile, tlsKeyFile := c.String("tls-cert-file"), c.String("tls-key-file")
+	if (tlsCertFile == "") != (tlsKeyFile == "") {
+		return fmt.Errorf("--tls-cert-file and --tls-key-file must be provided together")
+	}
+
+	host, _, err := net.SplitHostPort(listenAddr)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		logSugar.Warnw("proxy is listening on a non loopback address - anyone reaching it gets the permissions of the AWS identity", "listen", listenAddr)
+	}
+
+	_, err = configureAWSCredentials(c, "proxy::aws")
+	if err != nil {
+		return err
+	}
+
+	identity, err := getAWSIdentity(c.Context, *awsConfig)
+	if err != nil {
+		return err
+	}
+
+	eksClusterName := c.String("cluster-name")
+	cluster, err := getReadyEKSCluster(c.Context, eksClusterName, c.Bool("wait-for-active"), c.Duration("wait-timeout"), endpointAccess{})
+	if err != nil {
+		return err
+	}
+
+	logSugar.Info("validating certificateAuthorityData...")
+	ca, err := parseClusterCertificateAuthority(cluster)
+	if err != nil {
+		return err
+	}
+	logCertificateAuthority(ca)
+	certificateAuthorityData := ca.Data
+	tokenClusterID := getTokenClusterID(cluster, c.String("cluster-id"))
+
+	target, err := url.Parse(aws.ToString(cluster.Endpoint))
+	if err != nil {
+		return err
+	}
+
+	tokenSource := &eksTokenSource{
+		refresh: func() (string, error) {
+			// Start every refresh from a clean config so roles are re-assumed and OIDC tokens re-fetched
+			cfg, err := loadAWSConfig(c.Context, c.String("region"))
+			if err != nil {
+				return "", err
+			}
+			awsConfig = cfg
+
+			if _, err := configureAWSCredentials(c, "proxy::aws"); err != nil {
+				return "", err
+			}
+
+			token, err := presignEKSToken(c.Context, tokenClusterID)
+			if err != nil {
+				return "", err
+			}
+
+			return token, auditToken(c, identity, eksClusterName, aws.ToString(cluster.Arn), token)
+		},
+	}
+
+	proxy, err := newEKSReverseProxy(target, certificateAuthorityData, tokenSource)
+	if err != nil {
+		return err
+	}
+
+	scheme := "http"
+	if tlsCertFile != "" {
+		scheme = "https"
+
+		// Relative paths in a kubeconfig are resolved against the kubeconfig location
+		tlsCertFile, err = filepath.Abs(tlsCertFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	kubeconfigByteArr, err := generateProxyKubeconfig(c.String("region"), eksClusterName, aws.ToString(cluster.Arn), scheme+"://"+listenAddr, tlsCertFile)
+	if err != nil {
+		return err
+	}
+
+	logSugar.Infow("writing kubeconfig to file", "file", c.String("output-file"))
+	if err := writeToFile(c.String("output-file"), kubeconfigByteArr); err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(c.Context, syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	server := &http.Server{
+		Addr:    listenAddr,
+		Handler: proxy,
+	}
+
+	go func() {
+		<-ctx.Done()
+		logSugar.Info("received termination signal - stopping proxy")
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), proxyShutdownTimeout)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	logSugar.Infow("starting proxy", "listen", scheme+"://"+listenAddr, "upstream", target.String())
+	if tlsCertFile != "" {
+		err = server.ListenAndServeTLS(tlsCertFile, tlsKeyFile)
+	} else {
+		err = server.ListenAndServe()
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+
+	return err
+}
+
+// Creates a reverse proxy towards the EKS endpoint injecting a fresh bearer token into every request
+func newEKSReverseProxy(target *url.URL, certificateAuthorityData []byte, tokenSource *eksTokenSource) (*httputil.ReverseProxy, error) {
+
+	rootCAs := x509.NewCertPool()
+	if !rootCAs.AppendCertsFromPEM(certificateAuthorityData) {
+		return nil, fmt.Errorf("failed to parse cluster certificate authority data")
+	}
+
+	// HTTP/1.1 only - exec, attach and port-forward rely on connection upgrades which HTTP/2 does not support
+	transport := &http.Transport{
+		Proxy: network.proxyFunc(),
+		TLSClientConfig: &tls.Config{
+			RootCAs:    rootCAs,
+			MinVersion: tls.VersionTLS12,
+		},
+		IdleConnTimeout:     90 * time.Second,
+		TLSHandshakeTimeout: 10 * time.Second,
+	}
+
+	proxy := &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			req.URL.Scheme = target.Scheme
+			req.URL.Host = target.Host
+			req.Host = target.Host
+			req.Header.Del("Authorization")
+		},
+		Transport: &tokenInjectingTransport{base: transport, tokenSource: tokenSource},
+		// Flush immediately so watches and log streams are passed through as they arrive
+		FlushInterval: -1,
+		ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+			logSugar.Errorw("failed to proxy request", "method", req.Method, "path", req.URL.Path, "error", err)
+			w.WriteHeader(http.StatusBadGateway)
+		},
+	}
+
+	return proxy, nil
+}
+
+// tokenInjectingTransport sets the bearer token on every outgoing request
+type tokenInjectingTransport struct {
+	base        http.RoundTripper
+	tokenSource *eksTokenSource
+}
+
+// Implements http.RoundTripper
+func (t *tokenInjectingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+
+	token, err := t.tokenSource.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+token)
+	return t.base.RoundTrip(req)
+}
+
+// Function to generate a kubeconfig pointing at the local proxy
+func generateProxyKubeconfig(region, eksClusterName, clusterArn, proxyServer, tlsCertFile string) ([]byte, error) {
+
+	config := newKubeconfig(eksClusterName,
+		&api.Cluster{
+			Server:               proxyServer,
+			CertificateAuthority: tlsCertFile,
+		},
+		&api.AuthInfo{},
+		Provenance{ClusterType: clusterTypeEKS, Region: region, ClusterName: eksClusterName, ClusterArn: clusterArn},
+	)
+
+	return clientcmd.Write(*config)
+}
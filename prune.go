@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/eks"
+	"github.com/aws/aws-sdk-go-v2/service/eks/types"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/clientcmd/api"
+)
+
+const (
+	pruneReasonOrphaned        = "orphaned"
+	pruneReasonExpired         = "expired"
+	pruneReasonClusterNotFound = "cluster-not-found"
+)
+
+// staleContext is a qbconf managed context selected for removal
+type staleContext struct {
+	Name   string
+	Reason string
+}
+
+// Function to remove expired or orphaned qbconf managed entries from a kubeconfig file
+func pruneKubeconfig(ctx context.Context, kubeconfigPath string, dryRun, checkClusters bool) error {
+
+	logSugar.Infow("loading kubeconfig", "file", kubeconfigPath)
+	config, err := clientcmd.LoadFromFile(kubeconfigPath)
+	if err != nil {
+		return err
+	}
+
+	staleContexts, err := findStaleContexts(ctx, config, checkClusters)
+	if err != nil {
+		return err
+	}
+
+	if len(staleContexts) == 0 {
+		logSugar.Info("no stale qbconf managed contexts found")
+		return nil
+	}
+
+	for _, stale := range staleContexts {
+		removedClusters, removedAuthInfos := removeContext(config, stale.Name)
+
+		action := "removed"
+		if dryRun {
+			action = "would remove"
+		}
+
+		fmt.Printf("%s context %q (%s)\n", action, stale.Name, stale.Reason)
+		for _, name := range removedClusters {
+			fmt.Printf("%s cluster %q\n", action, name)
+		}
+		for _, name := range removedAuthInfos {
+			fmt.Printf("%s user %q\n", action, name)
+		}
+	}
+
+	if dryRun {
+		logSugar.Infow("dry run enabled - kubeconfig left untouched", "stale_contexts", len(staleContexts))
+		return nil
+	}
+
+	configBytes, err := clientcmd.Write(*config)
+	if err != nil {
+		return err
+	}
+
+	logSugar.Infow("writing pruned kubeconfig to file", "file", kubeconfigPath, "stale_contexts", len(staleContexts))
+	return writeToFile(kubeconfigPath, configBytes)
+}
+
+// Finds the qbconf managed contexts which are orphaned, hold an expired token or point at a deleted EKS cluster
+func findStaleContexts(ctx context.Context, config *api.Config, checkClusters bool) ([]staleContext, error) {
+
+	contextNames := make([]string, 0, len(config.Contexts))
+	for name := range config.Contexts {
+		contextNames = append(contextNames, name)
+	}
+	sort.Strings(contextNames)
+
+	// AWS configs are loaded lazily - one per region of the clusters we need to check
+	regionConfigs := map[string]*aws.Config{}
+
+	staleContexts := []staleContext{}
+	for _, name := range contextNames {
+		if !isQbconfManaged(config, name) {
+			continue
+		}
+
+		kubeContext := config.Contexts[name]
+		cluster, clusterExists := config.Clusters[kubeContext.Cluster]
+		authInfo, authInfoExists := config.AuthInfos[kubeContext.AuthInfo]
+
+		if !clusterExists || !authInfoExists {
+			staleContexts = append(staleContexts, staleContext{Name: name, Reason: pruneReasonOrphaned})
+			continue
+		}
+
+		if authInfo.Token != "" {
+			expiration, err := getTokenExpiration(authInfo.Token)
+			if err == nil && time.Now().After(expiration) {
+				staleContexts = append(staleContexts, staleContext{Name: name, Reason: pruneReasonExpired})
+				continue
+			}
+		}
+
+		if !checkClusters {
+			continue
+		}
+
+		region, clusterName := getRegionFromEndpoint(cluster.Server), kubeContext.Cluster
+		if provenance := getProvenance(cluster.Extensions); provenance != nil {
+			if provenance.ClusterType != "" && provenance.ClusterType != clusterTypeEKS {
+				continue
+			}
+			region, clusterName = provenance.Region, provenance.ClusterName
+		}
+		if region == "" {
+			logSugar.Warnw("unable to determine region of cluster - skipping existence check", "context", name)
+			continue
+		}
+
+		if _, ok := regionConfigs[region]; !ok {
+			cfg, err := loadAWSConfig(ctx, region)
+			if err != nil {
+				return nil, err
+			}
+			regionConfigs[region] = cfg
+		}
+
+		exists, err := eksClusterExists(ctx, *regionConfigs[region], clusterName)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			staleContexts = append(staleContexts, staleContext{Name: name, Reason: pruneReasonClusterNotFound})
+		}
+	}
+
+	return staleContexts, nil
+}
+
+// Checks with the EKS API if a cluster still exists
+func eksClusterExists(ctx context.Context, cfg aws.Config, eksClusterName string) (bool, error) {
+
+	logSugar.Infow("checking if EKS cluster exists", "cluster", eksClusterName, "region", cfg.Region)
+
+	eksSvc := eks.NewFromConfig(cfg)
+	_, err := eksSvc.DescribeCluster(ctx, &eks.DescribeClusterInput{
+		Name: aws.String(eksClusterName),
+	})
+	if err != nil {
+		var notFoundErr *types.ResourceNotFoundException
+		if errors.As(err, &notFoundErr) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
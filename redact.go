@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	redactedValue = "[REDACTED]"
+
+	// Secrets shorter than this are not registered - replacing them would garble unrelated log output
+	minRegisteredSecretLength = 8
+)
+
+var (
+	// Environment variables holding secrets which must never be logged
+	secretEnvVarNames = []string{"AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN", "ACTIONS_ID_TOKEN_REQUEST_TOKEN"}
+
+	// Access key IDs are masked - the first and last characters help identifying the key
+	accessKeyIDRegexp = regexp.MustCompile(`\b(?:AKIA|ASIA)[A-Z0-9]{16}\b`)
+
+	// Patterns of secrets which are replaced completely
+	secretRegexps = []*regexp.Regexp{
+		// k8s-aws-v1. bearer tokens
+		regexp.MustCompile(`k8s-aws-v1\.[A-Za-z0-9_-]+`),
+		// JWTs such as GitHub Actions OIDC tokens
+		regexp.MustCompile(`eyJ[A-Za-z0-9_-]*\.eyJ[A-Za-z0-9_-]*\.[A-Za-z0-9_-]*`),
+		// STS session tokens
+		regexp.MustCompile(`(?:IQoJb3JpZ2lu|FwoGZXIvYXdz|FQoGZXIvYXdz)[A-Za-z0-9/+=]+`),
+	}
+
+	// Patterns of secrets following a key - the key is kept, the value replaced
+	secretValueRegexps = []*regexp.Regexp{
+		// signatures and security tokens of presigned urls
+		regexp.MustCompile(`(?i)(X-Amz-Signature=|X-Amz-Security-Token=)[^&\s"']+`),
+		// secret access keys and session tokens in key value pairs
+		regexp.MustCompile(`(?i)((?:aws_)?secret_?access_?key["']?\s*[:=]\s*["']?|(?:aws_)?session_?token["']?\s*[:=]\s*["']?)[A-Za-z0-9/+=]+`),
+		// bearer tokens in headers
+		regexp.MustCompile(`(?i)(Bearer\s+)[A-Za-z0-9._~+/=-]{16,}`),
+	}
+
+	// Secrets obtained at runtime ( credentials, tokens ) replaced wherever they appear
+	registeredSecrets   = map[string]struct{}{}
+	registeredSecretsMu sync.RWMutex
+)
+
+func init() {
+	for _, name := range secretEnvVarNames {
+		registerSecret(os.Getenv(name))
+	}
+}
+
+// Registers a secret obtained at runtime so it is redacted from logs and errors
+func registerSecret(secret string) {
+
+	if len(secret) < minRegisteredSecretLength {
+		return
+	}
+
+	registeredSecretsMu.Lock()
+	defer registeredSecretsMu.Unlock()
+
+	registeredSecrets[secret] = struct{}{}
+}
+
+// Function to replace secrets in a string before it is logged or returned to the user
+func redactSecrets(s string) string {
+
+	registeredSecretsMu.RLock()
+	for secret := range registeredSecrets {
+		s = strings.ReplaceAll(s, secret, redactedValue)
+	}
+	registeredSecretsMu.RUnlock()
+
+	for _, expression := range secretRegexps {
+		s = expression.ReplaceAllString(s, redactedValue)
+	}
+	for _, expression := range secretValueRegexps {
+		s = expression.ReplaceAllString(s, "${1}"+redactedValue)
+	}
+
+	return accessKeyIDRegexp.ReplaceAllStringFunc(s, maskString)
+}
+
+// redactingCore redacts secrets from the message and fields of every log entry before it is written
+type redactingCore struct {
+	zapcore.Core
+}
+
+// Wraps a zap core with the redaction layer
+func newRedactingCore(core zapcore.Core) zapcore.Core {
+	return &redactingCore{Core: core}
+}
+
+// With implements zapcore.Core
+func (c *redactingCore) With(fields []zapcore.Field) zapcore.Core {
+	return &redactingCore{Core: c.Core.With(redactFields(fields))}
+}
+
+// Check implements zapcore.Core - the wrapper has to add itself so Write is called on it
+func (c *redactingCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(entry.Level) {
+		return checked.AddCore(entry, c)
+	}
+	return checked
+}
+
+// Write implements zapcore.Core
+func (c *redactingCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	entry.Message = redactSecrets(entry.Message)
+	entry.Stack = redactSecrets(entry.Stack)
+	return c.Core.Write(entry, redactFields(fields))
+}
+
+// Redacts secrets from string, error, binary and arbitrary fields
+func redactFields(fields []zapcore.Field) []zapcore.Field {
+
+	redacted := make([]zapcore.Field, 0, len(fields))
+	for _, field := range fields {
+		switch field.Type {
+		case zapcore.StringType:
+			field.String = redactSecrets(field.String)
+		case zapcore.ErrorType:
+			if err, ok := field.Interface.(error); ok {
+				field = zapcore.Field{Key: field.Key, Type: zapcore.StringType, String: redactSecrets(err.Error())}
+			}
+		case zapcore.StringerType:
+			if stringer, ok := field.Interface.(fmt.Stringer); ok {
+				field = zapcore.Field{Key: field.Key, Type: zapcore.StringType, String: redactSecrets(stringer.String())}
+			}
+		case zapcore.BinaryType, zapcore.ByteStringType:
+			// Binary fields are base64 encoded - redact before encoding hides the secret from the patterns
+			if raw, ok := field.Interface.([]byte); ok {
+				if value := redactSecrets(string(raw)); value != string(raw) {
+					field = zapcore.Field{Key: field.Key, Type: zapcore.StringType, String: value}
+				}
+			}
+		case zapcore.ReflectType, zapcore.ArrayMarshalerType, zapcore.ObjectMarshalerType:
+			if raw, err := json.Marshal(field.Interface); err == nil {
+				if value := redactSecrets(string(raw)); value != string(raw) {
+					field = zapcore.Field{Key: field.Key, Type: zapcore.StringType, String: value}
+				}
+			}
+		}
+		redacted = append(redacted, field)
+	}
+
+	return redacted
+}
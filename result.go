@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/urfave/cli/v2"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+var (
+	// Result of the current run - written to --result-file for downstream pipeline steps
+	runResult *RunResult
+)
+
+// RunResult is the machine-readable summary of a qbconf run
+type RunResult struct {
+	RequestUUID   string          `json:"request_uuid"`
+	Version       string          `json:"version,omitempty"`
+	Command       string          `json:"command"`
+	OperationMode string          `json:"operation_mode,omitempty"`
+	Success       bool            `json:"success"`
+	Error         string          `json:"error,omitempty"`
+	ErrorClass    string          `json:"error_class,omitempty"`
+	CallerArn     string          `json:"caller_arn,omitempty"`
+	Account       string          `json:"account,omitempty"`
+	RoleArn       string          `json:"role_arn,omitempty"`
+	OutputFiles   []string        `json:"output_files,omitempty"`
+	Clusters      []ClusterResult `json:"clusters"`
+	StartedAt     time.Time       `json:"started_at"`
+	FinishedAt    time.Time       `json:"finished_at"`
+}
+
+// ClusterResult describes the kubeconfig entries generated for a single cluster
+type ClusterResult struct {
+	Name        string     `json:"name"`
+	Arn         string     `json:"arn,omitempty"`
+	Endpoint    string     `json:"endpoint,omitempty"`
+	Context     string     `json:"context,omitempty"`
+	TokenExpiry *time.Time `json:"token_expiry,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	ErrorClass  string     `json:"error_class,omitempty"`
+}
+
+// Returns the flag enabling the run result
+func resultFileFlag() cli.Flag {
+	return &cli.StringFlag{
+		Name:     "result-file",
+		Usage:    "Writes a JSON summary of the run ( caller, clusters, outputs, token expiry, errors ) to a file - use - for stdout",
+		EnvVars:  []string{"QBCONF_RESULT_FILE"},
+		Required: false,
+	}
+}
+
+// Starts collecting the result of a run ( nil when --result-file is not set )
+func newRunResult(c *cli.Context, command string) (*RunResult, error) {
+
+	if c.String("result-file") == "" {
+		return nil, nil
+	}
+
+	// The runner reads the ::add-mask:: workflow commands from stdout - keep them out of the JSON and the JSON out of the runner log
+	if c.String("result-file") == "-" && c.Bool("gha-integration") && isGithubActions() {
+		return nil, fmt.Errorf("--result-file - can not be used with the GitHub Actions integration as the workflow commands masking secrets are written to stdout - write the result to a file or pass --gha-integration=false")
+	}
+
+	return &RunResult{
+		RequestUUID: reqUuid,
+		Version:     version,
+		Command:     command,
+		Clusters:    []ClusterResult{},
+		StartedAt:   time.Now().UTC(),
+	}, nil
+}
+
+// Records the operating mode and the caller identity
+func (r *RunResult) setIdentity(operationMode string, identity *sts.GetCallerIdentityOutput, roleArn string) {
+
+	if r == nil {
+		return
+	}
+
+	r.OperationMode = operationMode
+	r.CallerArn = aws.ToString(identity.Arn)
+	r.Account = aws.ToString(identity.Account)
+	r.RoleArn = roleArn
+}
+
+// Records the contexts of a generated kubeconfig and the file it was written to
+func (r *RunResult) addKubeconfig(outputFile string, kubeconfigByteArr []byte) {
+
+	if r == nil {
+		return
+	}
+
+	config, err := clientcmd.Load(kubeconfigByteArr)
+	if err != nil {
+		return
+	}
+
+	contextNames := make([]string, 0, len(config.Contexts))
+	for name := range config.Contexts {
+		contextNames = append(contextNames, name)
+	}
+	sort.Strings(contextNames)
+
+	for _, name := range contextNames {
+		kubeContext := config.Contexts[name]
+		result := ClusterResult{Name: kubeContext.Cluster, Context: name}
+
+		if cluster, ok := config.Clusters[kubeContext.Cluster]; ok {
+			result.Endpoint = cluster.Server
+			if provenance := getProvenance(cluster.Extensions); provenance != nil {
+				result.Name, result.Arn = provenance.ClusterName, provenance.ClusterArn
+			}
+		}
+		if authInfo, ok := config.AuthInfos[kubeContext.AuthInfo]; ok && authInfo.Token != "" {
+			if expiration, err := getTokenExpiration(authInfo.Token); err == nil {
+				result.TokenExpiry = &expiration
+			}
+		}
+
+		r.setCluster(result)
+	}
+
+	for _, file := range r.OutputFiles {
+		if file == outputFile {
+			return
+		}
+	}
+	r.OutputFiles = append(r.OutputFiles, outputFile)
+}
+
+// Adds or replaces the result of a cluster context
+func (r *RunResult) setCluster(result ClusterResult) {
+
+	for i, existing := range r.Clusters {
+		if existing.Name == result.Name && existing.Context == result.Context {
+			r.Clusters[i] = result
+			return
+		}
+	}
+	r.Clusters = append(r.Clusters, result)
+}
+
+// Function to finish the run result and write it to --result-file ( no-op when the flag is not set )
+func writeRunResult(c *cli.Context, clusterName string, runErr error) error {
+
+	if runResult == nil || c.String("result-file") == "" {
+		return nil
+	}
+
+	runResult.FinishedAt = time.Now().UTC()
+	runResult.Success = runErr == nil
+	runResult.Error, runResult.ErrorClass = "", ""
+
+	if runErr != nil {
+		runResult.Error = redactSecrets(runErr.Error())
+		runResult.ErrorClass = errorClassUnexpected
+
+		var qbconfErr *QbconfError
+		if errors.As(runErr, &qbconfErr) {
+			runResult.ErrorClass = qbconfErr.Class
+		}
+
+		// The failure belongs to the cluster the run was issuing credentials for
+		runResult.setCluster(ClusterResult{Name: clusterName, Error: runResult.Error, ErrorClass: runResult.ErrorClass})
+	}
+
+	resultBytes, err := json.MarshalIndent(runResult, "", "  ")
+	if err != nil {
+		return err
+	}
+	resultBytes = append(resultBytes, '\n')
+
+	if c.String("result-file") == "-" {
+		_, err = os.Stdout.Write(resultBytes)
+		return err
+	}
+
+	logSugar.Infow("writing run result to file", "file", c.String("result-file"))
+	return writeToFile(c.String("result-file"), resultBytes)
+}
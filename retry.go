@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/urfave/cli/v2"
+)
+
+var (
+	// Retry policy of the current command - shared by the AWS SDK clients and the GitHub OIDC token request
+	retryPolicy = RetryPolicy{MaxAttempts: 3, BaseDelay: time.Second, MaxDelay: 20 * time.Second}
+)
+
+// RetryPolicy retries throttling, 5xx and network errors with exponential backoff and full jitter
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+// httpStatusError is returned for unsuccessful HTTP responses outside of the AWS SDK
+type httpStatusError struct {
+	StatusCode int
+	Status     string
+}
+
+// Error implements the error interface for httpStatusError.
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("unexpected HTTP status %s", e.Status)
+}
+
+// HTTPStatusCode returns the status code of the response
+func (e *httpStatusError) HTTPStatusCode() int {
+	return e.StatusCode
+}
+
+// Returns the flags configuring the retry policy
+func retryFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.IntFlag{
+			Name:    "retry-max-attempts",
+			Usage:   "Maximum number of attempts of AWS and GitHub OIDC calls failing with throttling, 5xx or network errors",
+			EnvVars: []string{"QBCONF_RETRY_MAX_ATTEMPTS"},
+			Value:   retryPolicy.MaxAttempts,
+		},
+		&cli.DurationFlag{
+			Name:    "retry-base-delay",
+			Usage:   "Base delay of the exponential backoff between attempts",
+			EnvVars: []string{"QBCONF_RETRY_BASE_DELAY"},
+			Value:   retryPolicy.BaseDelay,
+		},
+		&cli.DurationFlag{
+			Name:    "retry-max-delay",
+			Usage:   "Maximum delay between attempts ( the actual delay is randomized between 0 and the backoff )",
+			EnvVars: []string{"QBCONF_RETRY_MAX_DELAY"},
+			Value:   retryPolicy.MaxDelay,
+		},
+	}
+}
+
+// Reads the retry policy from the command flags
+func newRetryPolicy(c *cli.Context) RetryPolicy {
+
+	policy := RetryPolicy{
+		MaxAttempts: c.Int("retry-max-attempts"),
+		BaseDelay:   c.Duration("retry-base-delay"),
+		MaxDelay:    c.Duration("retry-max-delay"),
+	}
+	if policy.MaxAttempts < 1 {
+		policy.MaxAttempts = 1
+	}
+
+	return policy
+}
+
+// Checks if an error is worth retrying - throttling, 5xx, transient and network errors only
+func isRetryableError(err error) bool {
+
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	// Errors stating themselves whether they are retryable ( e.g. AWS SDK credential and endpoint errors )
+	switch (retry.RetryableError{}).IsErrorRetryable(err) {
+	case aws.TrueTernary:
+		return true
+	case aws.FalseTernary:
+		return false
+	}
+
+	// Connection resets, refused connections and temporary network errors as the AWS SDK retries them
+	if (retry.RetryableConnectionError{}).IsErrorRetryable(err) == aws.TrueTernary {
+		return true
+	}
+
+	var statusErr interface{ HTTPStatusCode() int }
+	if errors.As(err, &statusErr) && statusErr.HTTPStatusCode() >= 500 {
+		return true
+	}
+
+	var qbconfErr *QbconfError
+	if errors.As(classifyAWSError(err), &qbconfErr) {
+		return qbconfErr.Class == errorClassThrottling || qbconfErr.Class == errorClassNetwork
+	}
+
+	return false
+}
+
+// Returns the full jitter delay before the given retry ( 1 based )
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+
+	// --retry-base-delay 0 retries immediately
+	if p.BaseDelay <= 0 || p.MaxDelay <= 0 {
+		return 0
+	}
+
+	// Clamp to the maximum delay - shifting too far overflows to a negative or zero duration
+	delay := p.MaxDelay
+	if attempt < 32 {
+		if exponential := p.BaseDelay << (attempt - 1); exponential > 0 && exponential < p.MaxDelay {
+			delay = exponential
+		}
+	}
+
+	return time.Duration(rand.Int63n(int64(delay) + 1))
+}
+
+// Function to run an operation with the retry policy - permanent errors are returned immediately
+func (p RetryPolicy) do(ctx context.Context, operation string, fn func() error) error {
+
+	var err error
+	for attempt := 1; attempt <= p.MaxAttempts; attempt++ {
+		err = fn()
+		if err == nil || !isRetryableError(err) || attempt == p.MaxAttempts {
+			return err
+		}
+
+		waitTime := p.backoff(attempt)
+		logSugar.Infow("retrying after retryable error", "operation", operation, "attempt", attempt, "max_attempts", p.MaxAttempts, "wait_time", waitTime, "error", err)
+		if sleepErr := sleepContext(ctx, waitTime); sleepErr != nil {
+			return sleepErr
+		}
+	}
+
+	return err
+}
+
+// Creates the AWS SDK retryer applying the retry policy to every AWS call ( STS, EKS, IAM )
+func (p RetryPolicy) newAWSRetryer() aws.Retryer {
+	return retry.NewStandard(func(options *retry.StandardOptions) {
+		options.MaxAttempts = p.MaxAttempts
+		options.MaxBackoff = p.MaxDelay
+		options.Backoff = retryBackoffFunc(func(attempt int, err error) (time.Duration, error) {
+			return p.backoff(attempt), nil
+		})
+		options.Retryables = []retry.IsErrorRetryable{
+			retry.NoRetryCanceledError{},
+			retry.IsErrorRetryableFunc(func(err error) aws.Ternary {
+				return aws.BoolTernary(isRetryableError(err))
+			}),
+		}
+		// Parallel jobs share nothing - do not let a client side retry quota stop retries early
+		options.RateLimiter = unlimitedRetryRateLimiter{}
+	})
+}
+
+// retryBackoffFunc adapts a function to retry.BackoffDelayer
+type retryBackoffFunc func(attempt int, err error) (time.Duration, error)
+
+// BackoffDelay implements retry.BackoffDelayer
+func (f retryBackoffFunc) BackoffDelay(attempt int, err error) (time.Duration, error) {
+	return f(attempt, err)
+}
+
+// unlimitedRetryRateLimiter never runs out of retry tokens
+type unlimitedRetryRateLimiter struct{}
+
+// GetToken implements retry.RateLimiter
+func (unlimitedRetryRateLimiter) GetToken(ctx context.Context, cost uint) (func() error, error) {
+	return func() error { return nil }, nil
+}
+
+// AddTokens implements retry.RateLimiter
+func (unlimitedRetryRateLimiter) AddTokens(uint) error {
+	return nil
+}
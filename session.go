@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"text/template"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts/types"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	// Unique per run so CloudTrail events can be joined to the run result and the audit log without extra trust policy permissions
+	defaultRoleSessionName = "qbconf-{{.RequestUUID}}"
+	// STS limits role session names and source identities to 2 - 64 characters
+	maxRoleSessionNameLength = 64
+	minRoleSessionNameLength = 2
+)
+
+var (
+	// Role session of the current command - traces assumed role sessions back to the run in CloudTrail
+	roleSession RoleSession
+
+	// Characters STS does not allow in role session names and source identities
+	invalidRoleSessionNameChars = regexp.MustCompile(`[^\w+=,.@-]`)
+	// Characters STS does not allow in session tag values
+	invalidSessionTagValueChars = regexp.MustCompile(`[^\p{L}\p{Z}\p{N}_.:/=+\-@]`)
+)
+
+// RoleSession holds the session name, source identity and session tags attached when assuming a role
+type RoleSession struct {
+	Name           string
+	SourceIdentity string
+	Tags           []types.Tag
+}
+
+// RoleSessionNameData is available to the --role-session-name template
+type RoleSessionNameData struct {
+	User        string
+	Host        string
+	RunID       string
+	Repository  string
+	RequestUUID string
+}
+
+// Returns the flags configuring the role session
+func roleSessionFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:     "role-session-name",
+			Usage:    "Name ( or template, e.g. qbconf-{{.User}}-{{.RunID}} ) of the AWS STS role session to create - fields: User, Host, RunID, Repository, RequestUUID",
+			EnvVars:  []string{"AWS_ROLE_SESSION_NAME"},
+			Value:    defaultRoleSessionName,
+			Required: false,
+		},
+		&cli.BoolFlag{
+			Name:    "session-tags",
+			Usage:   "Attaches a source identity and session tags ( request UUID, GitHub run ID and repository ) when assuming a role with --with-assume-role - the trust policy has to allow sts:TagSession and sts:SetSourceIdentity",
+			EnvVars: []string{"QBCONF_SESSION_TAGS"},
+			Value:   false,
+		},
+	}
+}
+
+// Creates the role session from the command flags
+func newRoleSession(c *cli.Context) (RoleSession, error) {
+
+	data := RoleSessionNameData{
+		User:        localUsername(),
+		RunID:       os.Getenv("GITHUB_RUN_ID"),
+		Repository:  os.Getenv("GITHUB_REPOSITORY"),
+		RequestUUID: reqUuid,
+	}
+	data.Host, _ = os.Hostname()
+
+	name, err := renderRoleSessionName(c.String("role-session-name"), data)
+	if err != nil {
+		return RoleSession{}, err
+	}
+
+	session := RoleSession{Name: name}
+	if !c.Bool("session-tags") {
+		return session, nil
+	}
+
+	session.SourceIdentity = sanitizeRoleSessionName("qbconf-" + reqUuid)
+	for _, tag := range []struct{ key, value string }{
+		{"qbconf:request-uuid", reqUuid},
+		{"qbconf:github-run-id", data.RunID},
+		{"qbconf:github-repository", data.Repository},
+	} {
+		if value := sanitizeSessionTagValue(tag.value); value != "" {
+			session.Tags = append(session.Tags, types.Tag{Key: aws.String(tag.key), Value: aws.String(value)})
+		}
+	}
+
+	return session, nil
+}
+
+// Renders the session name template and sanitizes the result to what STS accepts
+func renderRoleSessionName(nameTemplate string, data RoleSessionNameData) (string, error) {
+
+	tmpl, err := template.New("role-session-name").Option("missingkey=error").Parse(nameTemplate)
+	if err != nil {
+		return "", fmt.Errorf("invalid --role-session-name template: %w", err)
+	}
+
+	var rendered bytes.Buffer
+	if err := tmpl.Execute(&rendered, data); err != nil {
+		return "", fmt.Errorf("invalid --role-session-name template: %w", err)
+	}
+
+	name := sanitizeRoleSessionName(rendered.String())
+	if len(name) < minRoleSessionNameLength {
+		name = sanitizeRoleSessionName("qbconf-" + data.RequestUUID)
+		logSugar.Warnw("role session name is too short - using the default", "template", nameTemplate, "default", name)
+	}
+
+	return name, nil
+}
+
+// Replaces characters STS does not accept with - and truncates to the maximum length
+func sanitizeRoleSessionName(name string) string {
+
+	name = invalidRoleSessionNameChars.ReplaceAllString(name, "-")
+	if len(name) > maxRoleSessionNameLength {
+		name = name[:maxRoleSessionNameLength]
+	}
+
+	return name
+}
+
+// Removes characters STS does not accept in session tag values
+func sanitizeSessionTagValue(value string) string {
+
+	value = invalidSessionTagValueChars.ReplaceAllString(value, "-")
+	if runes := []rune(value); len(runes) > 256 {
+		value = string(runes[:256])
+	}
+
+	return value
+}
+
+// Applies the role session to the options of an assumed role
+func (s RoleSession) applyAssumeRoleOptions(o *stscreds.AssumeRoleOptions) {
+
+	o.RoleSessionName = s.Name
+	if s.SourceIdentity != "" {
+		o.SourceIdentity = aws.String(s.SourceIdentity)
+	}
+	o.Tags = s.Tags
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/clientcmd/api"
+)
+
+const (
+	authStyleAWSToken          = "aws-token"
+	authStyleToken             = "token"
+	authStyleExec              = "exec"
+	authStyleClientCertificate = "client-certificate"
+	authStyleAuthProvider      = "auth-provider"
+	authStyleBasic             = "basic"
+	authStyleNone              = "none"
+
+	// Exit code signalling the current context token expires within the threshold
+	statusExpiringExitCode = 3
+)
+
+// ContextStatus describes a single kubeconfig context as reported by the status command
+type ContextStatus struct {
+	Name             string     `json:"name"`
+	Current          bool       `json:"current"`
+	Cluster          string     `json:"cluster"`
+	Server           string     `json:"server"`
+	AuthStyle        string     `json:"authStyle"`
+	QbconfManaged    bool       `json:"qbconfManaged"`
+	ExpiresAt        *time.Time `json:"expiresAt,omitempty"`
+	RemainingSeconds *int64     `json:"remainingSeconds,omitempty"`
+}
+
+// Function to report the status ( endpoint, auth style and token expiry ) of every context in a kubeconfig file
+func kubeconfigStatus(out io.Writer, kubeconfigPath, outputFormat string, expiryThreshold time.Duration) error {
+
+	logSugar.Debugw("loading kubeconfig", "file", kubeconfigPath)
+	config, err := clientcmd.LoadFromFile(kubeconfigPath)
+	if err != nil {
+		return err
+	}
+
+	statuses := getContextStatuses(config, time.Now())
+
+	switch outputFormat {
+	case "json":
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(statuses); err != nil {
+			return err
+		}
+	case "table":
+		writeStatusTable(out, statuses)
+	default:
+		return fmt.Errorf("unsupported output format: %s", outputFormat)
+	}
+
+	for _, status := range statuses {
+		if status.Current && status.RemainingSeconds != nil && time.Duration(*status.RemainingSeconds)*time.Second <= expiryThreshold {
+			return cli.Exit(fmt.Sprintf("token of current context %q expires within %s", status.Name, expiryThreshold), statusExpiringExitCode)
+		}
+	}
+
+	return nil
+}
+
+// Builds the status of every context in the kubeconfig sorted by name
+func getContextStatuses(config *api.Config, now time.Time) []ContextStatus {
+
+	contextNames := make([]string, 0, len(config.Contexts))
+	for name := range config.Contexts {
+		contextNames = append(contextNames, name)
+	}
+	sort.Strings(contextNames)
+
+	statuses := make([]ContextStatus, 0, len(contextNames))
+	for _, name := range contextNames {
+		kubeContext := config.Contexts[name]
+
+		status := ContextStatus{
+			Name:          name,
+			Current:       name == config.CurrentContext,
+			Cluster:       kubeContext.Cluster,
+			AuthStyle:     authStyleNone,
+			QbconfManaged: isQbconfManaged(config, name),
+		}
+
+		if cluster, ok := config.Clusters[kubeContext.Cluster]; ok {
+			status.Server = cluster.Server
+		}
+
+		if authInfo, ok := config.AuthInfos[kubeContext.AuthInfo]; ok {
+			status.AuthStyle = getAuthStyle(authInfo)
+
+			if status.AuthStyle == authStyleAWSToken {
+				if expiration, err := getTokenExpiration(authInfo.Token); err == nil {
+					remaining := int64(expiration.Sub(now).Seconds())
+					status.ExpiresAt = &expiration
+					status.RemainingSeconds = &remaining
+				}
+			}
+		}
+
+		statuses = append(statuses, status)
+	}
+
+	return statuses
+}
+
+// Determines how a kubeconfig user authenticates against the cluster
+func getAuthStyle(authInfo *api.AuthInfo) string {
+
+	switch {
+	case strings.HasPrefix(authInfo.Token, v1Prefix):
+		return authStyleAWSToken
+	case authInfo.Token != "" || authInfo.TokenFile != "":
+		return authStyleToken
+	case authInfo.Exec != nil:
+		return authStyleExec
+	case len(authInfo.ClientCertificateData) > 0 || authInfo.ClientCertificate != "":
+		return authStyleClientCertificate
+	case authInfo.AuthProvider != nil:
+		return authStyleAuthProvider
+	case authInfo.Username != "":
+		return authStyleBasic
+	default:
+		return authStyleNone
+	}
+}
+
+// Writes the context statuses as a human readable table
+func writeStatusTable(out io.Writer, statuses []ContextStatus) {
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CURRENT\tNAME\tSERVER\tAUTH\tEXPIRES IN")
+
+	for _, status := range statuses {
+		current := ""
+		if status.Current {
+			current = "*"
+		}
+
+		expiresIn := "-"
+		if status.RemainingSeconds != nil {
+			remaining := time.Duration(*status.RemainingSeconds) * time.Second
+			if remaining <= 0 {
+				expiresIn = "expired"
+			} else {
+				expiresIn = remaining.String()
+			}
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", current, status.Name, status.Server, status.AuthStyle, expiresIn)
+	}
+
+	w.Flush()
+}
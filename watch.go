@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	// Delay before retrying a failed refresh in watch mode
+	watchRetryInterval = 30 * time.Second
+)
+
+// Function to keep the kubeconfig fresh by regenerating it before the presigned token expires
+func watchKubeconfigEKS(c *cli.Context) error {
+
+	refreshInterval := c.Duration("refresh-interval")
+	if refreshInterval <= 0 || refreshInterval >= presignedURLExpiration {
+		return fmt.Errorf("refresh interval must be between 0 and %s", presignedURLExpiration)
+	}
+
+	ctx, stop := signal.NotifyContext(c.Context, syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	logSugar.Infow("starting watch mode", "refresh_interval", refreshInterval)
+
+	lastRefresh := time.Time{}
+	for {
+		waitTime := refreshInterval
+
+		err := generateKubeconfigAWS(c)
+		switch {
+		case err == nil:
+			lastRefresh = time.Now()
+			logSugar.Infow("refreshed kubeconfig", "next_refresh", lastRefresh.Add(refreshInterval))
+		case lastRefresh.IsZero():
+			// Fail fast when the very first generation does not succeed
+			logSugar.Error(err)
+			return err
+		default:
+			logSugar.Errorw("failed to refresh kubeconfig", "error", err, "last_refresh", lastRefresh)
+			waitTime = watchRetryInterval
+		}
+
+		if sleepContext(ctx, waitTime) != nil {
+			logSugar.Info("received termination signal - stopping watch mode")
+			return nil
+		}
+
+		// Start every refresh from a clean config so roles are re-assumed and OIDC tokens re-fetched
+		for {
+			cfg, err := loadAWSConfig(ctx, c.String("region"))
+			if err == nil {
+				awsConfig = cfg
+				break
+			}
+
+			// Keep the previous config - e.g. a CA bundle being rotated or a broken shared config file may recover
+			logSugar.Errorw("failed to reload AWS config", "error", err, "retry_in", watchRetryInterval)
+			if sleepContext(ctx, watchRetryInterval) != nil {
+				logSugar.Info("received termination signal - stopping watch mode")
+				return nil
+			}
+		}
+	}
+}
+
+// Merges the generated kubeconfig into an existing kubeconfig file
+func mergeKubeconfig(kubeconfigPath string, generatedBytes []byte) ([]byte, error) {
+
+	generated, err := clientcmd.Load(generatedBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	existing, err := clientcmd.LoadFromFile(kubeconfigPath)
+	if errors.Is(err, os.ErrNotExist) {
+		logSugar.Infow("kubeconfig to merge into does not exist yet", "file", kubeconfigPath)
+		return generatedBytes, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for name, cluster := range generated.Clusters {
+		existing.Clusters[name] = cluster
+	}
+	for name, authInfo := range generated.AuthInfos {
+		existing.AuthInfos[name] = authInfo
+	}
+	for name, kubeContext := range generated.Contexts {
+		existing.Contexts[name] = kubeContext
+	}
+	existing.CurrentContext = generated.CurrentContext
+
+	logSugar.Infow("merged generated entries into existing kubeconfig", "file", kubeconfigPath)
+	return clientcmd.Write(*existing)
+}